@@ -3,6 +3,11 @@ package app
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"github.com/andybalholm/brotli"
 	lru "github.com/hashicorp/golang-lru"
@@ -10,19 +15,38 @@ import (
 	"go-http-server/util"
 	"golang.org/x/exp/slices"
 	"io"
+	"io/fs"
+	"log/slog"
 	"mime"
+	"net"
 	"net/http"
 	"os"
 	"path"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 type App struct {
-	params *param.Params
-	server *http.Server
-	cache  *lru.TwoQueueCache
+	params       *param.Params
+	server       *http.Server
+	cache        *lru.TwoQueueCache
+	healthChecks []healthCheckEntry
+	// resolved paths of existing files by URL path, only kept with the cache enabled
+	filePaths *sync.Map
+	// rendered directory listings by directory path, only kept with the cache enabled
+	listings *sync.Map
+	// per-directory overrides by directory path
+	directoryConfigs map[string]DirectoryConfig
+	// archive file system served instead of the directory, see ServeFS
+	fsys fs.FS
+	// request counters served on MetricsPath, nil when disabled
+	metrics *util.Metrics
+	// address the server is bound to, nil until Serve
+	addr net.Addr
 }
 
 type ResponseItem struct {
@@ -31,6 +55,8 @@ type ResponseItem struct {
 	ModTime     time.Time
 	Content     []byte
 	ContentType string
+	// strong entity tag of Content, empty unless ETags are enabled
+	ETag string
 }
 
 type Compression int
@@ -41,6 +67,90 @@ const (
 	Brotli
 )
 
+// compressionSource log values
+const (
+	CompressionSourceDisk    = "disk"
+	CompressionSourceRuntime = "runtime"
+	CompressionSourceNone    = "none"
+)
+
+// runtimeCompressionSource is resolved when the access line is written, after
+// CompressResponses had its chance to compress the response
+type runtimeCompressionSource struct {
+	header http.Header
+}
+
+func (s runtimeCompressionSource) LogValue() slog.Value {
+	if s.header.Get("Content-Encoding") != "" {
+		return slog.StringValue(CompressionSourceRuntime)
+	}
+	return slog.StringValue(CompressionSourceNone)
+}
+
+// compression decision log values, explaining why a response was not
+// compressed, otherwise the decision is the served encoding
+const (
+	CompressionDecisionRange          = "range"
+	CompressionDecisionIneligible     = "ineligible"
+	CompressionDecisionDisabled       = "disabled"
+	CompressionDecisionBelowThreshold = "below-threshold"
+	CompressionDecisionNotAccepted    = "not-accepted"
+	CompressionDecisionNoVariant      = "no-variant"
+)
+
+// compressionDecision groups the inputs of the pre-compressed variant
+// selection with its outcome. Like runtimeCompressionSource it is resolved
+// when the access line is written, an encoding CompressResponses applied
+// after the selection replaces its decision
+type compressionDecision struct {
+	header         http.Header
+	acceptEncoding string
+	eligible       bool
+	size           int
+	threshold      int64
+	decision       string
+}
+
+func (app *App) compressionDecision(w http.ResponseWriter, r *http.Request, size int, eligible bool, decision string) slog.Attr {
+	return slog.Any("compression", compressionDecision{
+		header:         w.Header(),
+		acceptEncoding: r.Header.Get("Accept-Encoding"),
+		eligible:       eligible,
+		size:           size,
+		threshold:      app.params.Threshold,
+		decision:       decision,
+	})
+}
+
+func (d compressionDecision) LogValue() slog.Value {
+	decision := d.decision
+	if encoding := d.header.Get("Content-Encoding"); encoding != "" {
+		decision = encoding
+	}
+	return slog.GroupValue(
+		slog.String("acceptEncoding", d.acceptEncoding),
+		slog.Bool("eligible", d.eligible),
+		slog.Int("size", d.size),
+		slog.Int64("threshold", d.threshold),
+		slog.Bool("meetsThreshold", int64(d.size) > d.threshold),
+		slog.String("decision", decision),
+	)
+}
+
+// DefaultEncodingPreference is used when EncodingPreference is not configured
+var DefaultEncodingPreference = []string{"br", "gzip"}
+
+// fixedContentTypes do not depend on the mime.types of the host,
+// WebAssembly.instantiateStreaming rejects anything but application/wasm
+var fixedContentTypes = map[string]string{
+	".wasm": "application/wasm",
+}
+
+var encodingCompressions = map[string]Compression{
+	"br":   Brotli,
+	"gzip": Gzip,
+}
+
 func NewApp(params *param.Params) App {
 	var cache *lru.TwoQueueCache = nil
 	var err error
@@ -52,7 +162,59 @@ func NewApp(params *param.Params) App {
 		}
 	}
 
-	return App{params: params, server: nil, cache: cache}
+	newApp := App{params: params, server: nil, cache: cache}
+	if cache != nil {
+		newApp.filePaths = &sync.Map{}
+		newApp.listings = &sync.Map{}
+	}
+	if params.MetricsPath != "" {
+		newApp.metrics = util.NewMetrics()
+	}
+	newApp.AddHealthCheck("directory", DirectoryHealthCheck(params.Directory))
+	return newApp
+}
+
+// EnabledEncodings returns the enabled content-codings in preference order
+func (app *App) EnabledEncodings() []string {
+	preference := app.params.EncodingPreference
+	if len(preference) == 0 {
+		preference = DefaultEncodingPreference
+	}
+
+	encodings := make([]string, 0, len(preference))
+	for _, encoding := range preference {
+		if (encoding == "br" && app.params.Brotli) || (encoding == "gzip" && app.params.Gzip) {
+			encodings = append(encodings, encoding)
+		}
+	}
+	return encodings
+}
+
+// BrotliDenied reports whether r comes from a client matching
+// BrotliDenyUserAgents, which is served gzip even if it accepts br
+func (app *App) BrotliDenied(r *http.Request) bool {
+	userAgent := r.UserAgent()
+	for _, pattern := range app.params.BrotliDenyUserAgents {
+		if pattern.MatchString(userAgent) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestEncodings are the EnabledEncodings for r
+func (app *App) requestEncodings(r *http.Request) []string {
+	encodings := app.EnabledEncodings()
+	if !app.BrotliDenied(r) {
+		return encodings
+	}
+	allowed := encodings[:0]
+	for _, encoding := range encodings {
+		if encoding != "br" {
+			allowed = append(allowed, encoding)
+		}
+	}
+	return allowed
 }
 
 func (app *App) ShouldSkipCompression(filePath string) bool {
@@ -66,7 +228,13 @@ func (app *App) ShouldSkipCompression(filePath string) bool {
 }
 
 func (app *App) CompressFiles() {
-	if !app.params.Gzip && !app.params.Brotli {
+	// archives are read-only, they have to ship their own variants
+	if (!app.params.Gzip && !app.params.Brotli) || app.fsys != nil {
+		return
+	}
+	// huge trees would keep the process busy compressing long after it started serving
+	if limit := app.params.CompressMaxFiles; limit > 0 && countFiles(app.params.Directory, limit+1) > limit {
+		slog.Warn("Skipping compression at startup, too many files", "directory", app.params.Directory, "maxFiles", limit)
 		return
 	}
 	err := filepath.Walk(app.params.Directory, func(filePath string, info os.FileInfo, err error) error {
@@ -88,26 +256,37 @@ func (app *App) CompressFiles() {
 		}
 
 		if info.Size() > app.params.Threshold {
-			data, _ := os.ReadFile(filePath)
-
-			if app.params.Gzip {
-				newName := filePath + ".gz"
-				file, _ := os.Create(newName)
+			quality := brotli.DefaultCompression
+			if app.params.BrotliQuality > 0 {
+				quality = app.params.BrotliQuality
+			}
+			var data []byte
+			compress := func(newName string, newWriter func(io.Writer) io.WriteCloser) {
+				// keep variants produced by the build pipeline unless the source is newer
+				if variant, err := os.Stat(newName); err == nil && !variant.ModTime().Before(info.ModTime()) {
+					return
+				}
+				if data == nil {
+					data, _ = os.ReadFile(filePath)
+				}
+				file, err := os.Create(newName)
+				if err != nil {
+					return
+				}
+				defer file.Close()
 
-				writer := gzip.NewWriter(file)
+				writer := newWriter(file)
 
 				_, _ = writer.Write(data)
 				_ = writer.Close()
 			}
 
-			if app.params.Brotli {
-				newName := filePath + ".br"
-				file, _ := os.Create(newName)
-
-				writer := brotli.NewWriter(file)
+			if app.params.Gzip {
+				compress(filePath+".gz", func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) })
+			}
 
-				_, _ = writer.Write(data)
-				_ = writer.Close()
+			if app.params.Brotli {
+				compress(filePath+".br", func(w io.Writer) io.WriteCloser { return brotli.NewWriterLevel(w, quality) })
 			}
 		}
 
@@ -119,6 +298,21 @@ func (app *App) CompressFiles() {
 	}
 }
 
+// countFiles counts the files below directory, stopping at limit
+func countFiles(directory string, limit int) int {
+	count := 0
+	_ = filepath.WalkDir(directory, func(filePath string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			count++
+		}
+		if count >= limit {
+			return fs.SkipAll
+		}
+		return nil
+	})
+	return count
+}
+
 func (app *App) GetOrCreateResponseItem(requestedPath string, compression Compression, actualContentType *string) (*ResponseItem, int) {
 	rootIndexPath := path.Join(app.params.Directory, "index.html")
 
@@ -142,13 +336,12 @@ func (app *App) GetOrCreateResponseItem(requestedPath string, compression Compre
 		}
 	}
 
-	dirPath, fileName := filepath.Split(requestedPath)
-	dir := http.Dir(dirPath)
-
-	file, err := dir.Open(fileName)
+	// only needed past the cache, which serves the hot path
+	fallbackPath := app.fallbackPath()
+	file, err := app.open(requestedPath)
 	if err != nil {
-		if app.params.SpaMode && compression == None && requestedPath != rootIndexPath {
-			newPath := path.Join(app.params.Directory, "index.html")
+		if app.params.SpaMode && compression == None && requestedPath != fallbackPath {
+			newPath := app.fallbackPath()
 			if app.cache != nil {
 				app.cache.Add(requestedPath, newPath)
 			}
@@ -160,8 +353,8 @@ func (app *App) GetOrCreateResponseItem(requestedPath string, compression Compre
 
 	stat, err := file.Stat()
 	if err != nil {
-		if app.params.SpaMode && compression == None && requestedPath != rootIndexPath {
-			newPath := path.Join(app.params.Directory, "index.html")
+		if app.params.SpaMode && compression == None && requestedPath != fallbackPath {
+			newPath := app.fallbackPath()
 			if app.cache != nil {
 				app.cache.Add(requestedPath, newPath)
 			}
@@ -171,8 +364,15 @@ func (app *App) GetOrCreateResponseItem(requestedPath string, compression Compre
 	}
 
 	if stat.IsDir() && requestedPath != rootIndexPath {
-		if app.params.SpaMode && compression == None {
-			newPath := path.Join(app.params.Directory, "index.html")
+		if app.params.SpaMode && compression == None && requestedPath == path.Clean(app.params.Directory) {
+			// the root is served from its index.html, a missing one falls back above
+			newPath := rootIndexPath
+			if app.cache != nil {
+				app.cache.Add(requestedPath, newPath)
+			}
+			return app.GetOrCreateResponseItem(newPath, compression, actualContentType)
+		} else if app.params.SpaMode && compression == None {
+			newPath := app.fallbackPath()
 			if app.cache != nil {
 				app.cache.Add(requestedPath, newPath)
 			}
@@ -197,7 +397,14 @@ func (app *App) GetOrCreateResponseItem(requestedPath string, compression Compre
 	name := stat.Name()
 	var contentType string
 	if compression == None {
-		contentType = mime.TypeByExtension(filepath.Ext(name))
+		var fixed bool
+		if contentType, fixed = fixedContentTypes[strings.ToLower(filepath.Ext(name))]; !fixed {
+			contentType = mime.TypeByExtension(filepath.Ext(name))
+		}
+		// an empty content type lets http.ServeContent sniff it from the content
+		if contentType == "" && app.params.NoSniff {
+			contentType = "application/octet-stream"
+		}
 	} else {
 		contentType = *actualContentType
 	}
@@ -209,6 +416,14 @@ func (app *App) GetOrCreateResponseItem(requestedPath string, compression Compre
 		Content:     content,
 		ContentType: contentType,
 	}
+	if app.params.ETag && app.params.ETagStrategy == param.ETagStrategyModTime {
+		// variants differ in size, which keeps their tags distinct without hashing
+		responseItem.ETag = fmt.Sprintf(`W/"%x-%x"`, stat.Size(), stat.ModTime().UnixNano())
+	} else if app.params.ETag {
+		// hashing each variant keeps tags distinct between encodings
+		sum := sha256.Sum256(content)
+		responseItem.ETag = `"` + hex.EncodeToString(sum[:16]) + `"`
+	}
 
 	if app.cache != nil {
 		app.cache.Add(requestedPath, responseItem)
@@ -218,102 +433,626 @@ func (app *App) GetOrCreateResponseItem(requestedPath string, compression Compre
 }
 
 func (app *App) GetFilePath(urlPath string) (string, bool) {
+	// stat and symlink resolution dominate serving cached files, remember them
+	// like the cache remembers contents. Only canonical spellings of existing
+	// files are kept, so "/x/../app.js" or "//app.js" cannot grow the map
+	canonical := urlPath == path.Clean("/"+urlPath)
+	if app.filePaths != nil && canonical {
+		if cached, ok := app.filePaths.Load(urlPath); ok {
+			return cached.(string), true
+		}
+	}
+
+	// urlPath is already percent-decoded, an encoded "%2e%2e%2f" arrives as "../"
+	// and is caught by the containment check below
 	requestedPath := path.Join(app.params.Directory, urlPath)
 
-	if _, err := os.Stat(requestedPath); !os.IsNotExist(err) {
+	exists := false
+	if app.fsys != nil {
+		// archives are looked up by name, their symlinks are not served
+		_, err := app.stat(requestedPath)
+		exists = err == nil
+	} else if _, err := os.Stat(requestedPath); !os.IsNotExist(err) {
 		requestedPath, err = filepath.EvalSymlinks(requestedPath)
+		exists = err == nil
 	}
 
-	if !strings.HasPrefix(requestedPath, app.params.Directory) {
+	// a plain prefix check would let "/srv/www" match "/srv/www-private" too
+	directory := strings.TrimSuffix(app.params.Directory, "/")
+	if requestedPath != directory && !strings.HasPrefix(requestedPath, directory+"/") {
 		return "", false
 	}
 
+	if exists && canonical && app.filePaths != nil {
+		app.filePaths.Store(urlPath, requestedPath)
+	}
 	return requestedPath, true
 }
 
+// IsHostAllowed matches the request host name against AllowedHosts, requests
+// without a Host are rejected too. http.Server already rejects HTTP/1.1
+// requests without a Host header when no allow-list is configured
+func (app *App) IsHostAllowed(r *http.Request) bool {
+	if len(app.params.AllowedHosts) == 0 {
+		return true
+	}
+
+	hostname := r.Host
+	if host, _, err := net.SplitHostPort(r.Host); err == nil {
+		hostname = host
+	}
+	// "example.com." is the fully qualified form of "example.com"
+	hostname = strings.TrimSuffix(hostname, ".")
+	for _, allowed := range app.params.AllowedHosts {
+		if strings.EqualFold(hostname, strings.TrimSuffix(allowed, ".")) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsPathAllowed matches the cleaned URL path against AllowPaths, entries ending
+// with "*" match as prefixes, other entries must match exactly
+func (app *App) IsPathAllowed(urlPath string) bool {
+	urlPath = path.Clean("/" + urlPath)
+	for _, allowed := range app.params.AllowPaths {
+		if prefix, ok := strings.CutSuffix(allowed, "*"); ok {
+			if strings.HasPrefix(urlPath, prefix) {
+				return true
+			}
+		} else if urlPath == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// bypassesFallback reports whether urlPath is under one of SpaBypassPrefixes,
+// "/api" matches "/api" and "/api/users" but not "/apis"
+func (app *App) bypassesFallback(urlPath string) bool {
+	urlPath = path.Clean("/" + urlPath)
+	for _, prefix := range app.params.SpaBypassPrefixes {
+		prefix = strings.TrimSuffix(prefix, "/")
+		if urlPath == prefix || strings.HasPrefix(urlPath, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// isRoute reports whether urlPath can be a client-side route, i.e. it has no
+// file extension or one of SpaRouteExtensions
+func (app *App) isRoute(urlPath string) bool {
+	ext := path.Ext(urlPath)
+	if ext == "" {
+		return true
+	}
+	for _, routeExt := range app.params.SpaRouteExtensions {
+		if strings.EqualFold(strings.TrimPrefix(routeExt, "."), ext[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// isNavigation reports whether the request looks like a browser navigation,
+// i.e. it accepts text/html and its path is a route
+func (app *App) isNavigation(r *http.Request) bool {
+	return app.isRoute(r.URL.Path) && util.AcceptsMediaType(r.Header.Get("Accept"), "text/html")
+}
+
+// cacheRule log values, naming the rule that picked the Cache-Control value
+const (
+	CacheRuleIgnorePath      = "ignore-path"
+	CacheRulePattern         = "pattern"
+	CacheRuleHTML            = "html"
+	CacheRuleImmutable       = "immutable"
+	CacheRuleMaxAge          = "max-age"
+	CacheRuleDirectoryConfig = "directory-config"
+)
+
+// CacheControl returns the Cache-Control value for a served file, along with
+// the name of the rule that picked it
+func (app *App) CacheControl(urlPath string, name string) (rule, value string) {
+	if slices.Contains(app.params.IgnoreCacheControlPaths, urlPath) {
+		return CacheRuleIgnorePath, "no-store"
+	}
+	for _, entry := range app.params.CacheControlRules {
+		if entry.Matches(urlPath, name) {
+			return CacheRulePattern, entry.Value
+		}
+	}
+	if path.Ext(name) == ".html" {
+		return CacheRuleHTML, "no-store"
+	}
+	if app.params.ImmutablePattern != nil && app.params.ImmutablePattern.MatchString(name) {
+		// fingerprinted file names change with their content
+		return CacheRuleImmutable, "public, max-age=31536000, immutable"
+	}
+	return CacheRuleMaxAge, "max-age=" + strconv.FormatInt(app.params.CacheControlMaxAge, 10)
+}
+
+// writeRootNoIndex answers "/" when the served directory has no index.html,
+// the SPA fallback has nothing to serve either then
+func (app *App) writeRootNoIndex(w http.ResponseWriter) {
+	status := app.params.RootNoIndexStatus
+	if status == 0 {
+		status = http.StatusNotFound
+	}
+	if app.params.RootNoIndexMessage == "" {
+		w.WriteHeader(status)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_, _ = io.WriteString(w, app.params.RootNoIndexMessage+"\n")
+}
+
+// cspNonce returns 128 random bits, base64 encoded as CSP nonce-source expects
+func cspNonce() string {
+	nonce := make([]byte, 16)
+	_, _ = rand.Read(nonce)
+	return base64.StdEncoding.EncodeToString(nonce)
+}
+
+// deniedUserAgent returns the first DenyUserAgents pattern matching userAgent.
+// Requests without a User-Agent are never denied, whatever the patterns
+func (app *App) deniedUserAgent(userAgent string) *regexp.Regexp {
+	if userAgent == "" {
+		return nil
+	}
+	for _, pattern := range app.params.DenyUserAgents {
+		if pattern.MatchString(userAgent) {
+			return pattern
+		}
+	}
+	return nil
+}
+
+// fallbackPath is the file the SPA fallback serves, index.html unless FallbackFile is set
+func (app *App) fallbackPath() string {
+	if app.params.FallbackFile == "" {
+		return path.Join(app.params.Directory, "index.html")
+	}
+	return path.Join(app.params.Directory, app.params.FallbackFile)
+}
+
+// serveContent serves responseItem, a fallback with a FallbackStatus other than
+// 200 is written as is since conditional and range requests only apply to 200s
+func (app *App) serveContent(w http.ResponseWriter, r *http.Request, responseItem *ResponseItem, status int) {
+	if status == http.StatusOK {
+		http.ServeContent(w, r, responseItem.Name, responseItem.ModTime, bytes.NewReader(responseItem.Content))
+		return
+	}
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", http.DetectContentType(responseItem.Content))
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(responseItem.Content)))
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		_, _ = w.Write(responseItem.Content)
+	}
+}
+
+// isFallback reports whether requestedPath would be answered with the SPA fallback file
+func (app *App) isFallback(requestedPath string) bool {
+	return app.params.SpaMode && requestedPath != path.Clean(app.params.Directory) && app.fileType(requestedPath) != util.FileTypeFile
+}
+
 func (app *App) HandlerFuncNew(w http.ResponseWriter, r *http.Request) {
-	requestedPath, valid := app.GetFilePath(r.URL.Path)
+	if app.answerEarly(w, r) {
+		return
+	}
+	app.serveRequest(w, r)
+}
+
+// answerEarly rejects requests for other hosts, too long queries and denied
+// user agents, and answers "OPTIONS *" and health probes. It reports whether
+// the request was answered
+func (app *App) answerEarly(w http.ResponseWriter, r *http.Request) bool {
+	if !app.IsHostAllowed(r) {
+		w.WriteHeader(http.StatusBadRequest)
+		return true
+	}
+
+	if app.params.MaxQueryLength > 0 && len(r.URL.RawQuery) > app.params.MaxQueryLength {
+		w.WriteHeader(http.StatusRequestURITooLong)
+		return true
+	}
+
+	// server-wide "OPTIONS *" probe, answered without touching the filesystem
+	if r.Method == http.MethodOptions && r.RequestURI == "*" {
+		w.Header().Set("Allow", "GET, HEAD, OPTIONS")
+		w.WriteHeader(http.StatusNoContent)
+		return true
+	}
+
+	if app.params.HealthPath != "" && r.URL.Path == app.params.HealthPath {
+		app.HealthHandler(w, r)
+		return true
+	}
+
+	if pattern := app.deniedUserAgent(r.UserAgent()); pattern != nil {
+		util.AddLogAttrs(r, slog.String("deniedUserAgent", pattern.String()))
+		w.WriteHeader(http.StatusForbidden)
+		return true
+	}
+	return false
+}
+
+// serveRequest serves the requests answerEarly let through
+func (app *App) serveRequest(w http.ResponseWriter, r *http.Request) {
+	if len(app.params.AuthRules) > 0 && !app.Authorize(w, r) {
+		return
+	}
+
+	// metrics are behind the deny-list and auth, unlike the health probe
+	if app.metrics != nil && r.URL.Path == app.params.MetricsPath {
+		app.metrics.ServeHTTP(w, r)
+		return
+	}
+
+	if len(app.params.AllowPaths) > 0 && !app.IsPathAllowed(r.URL.Path) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	urlPath := r.URL.Path
+	if len(app.params.TryFiles) > 0 {
+		target, code := app.TryFiles(urlPath)
+		if code != 0 {
+			w.WriteHeader(code)
+			return
+		}
+		urlPath = target
+	}
+
+	requestedPath, valid := app.GetFilePath(urlPath)
 
 	if !valid {
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
 
+	if app.params.DirectoryConfig && filepath.Base(requestedPath) == DirectoryConfigName {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if app.params.DirectoryListingJSON && r.URL.Query().Get("format") == "json" && app.fileType(requestedPath) == util.FileTypeDirectory {
+		app.ServeDirectoryListing(w, r, requestedPath)
+		return
+	}
+
+	if slices.Contains(app.params.NoContentPaths, r.URL.Path) && app.fileType(requestedPath) == util.FileTypeNotExists {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// the index.html shell is no answer to a form POST or API call on a client-side route
+	if r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodOptions && app.isFallback(requestedPath) {
+		w.Header().Set("Allow", "GET, HEAD, OPTIONS")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	// the cheap path checks go first, isFallback stats the requested file
+	if len(app.params.SpaBypassPrefixes) > 0 && app.bypassesFallback(r.URL.Path) && app.isFallback(requestedPath) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// with SpaRouteExtensions the fallback only answers paths that can be routes
+	if len(app.params.SpaRouteExtensions) > 0 && !app.isRoute(r.URL.Path) && app.isFallback(requestedPath) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// with SpaHtmlOnly only browser navigations get index.html for unknown paths
+	if app.params.SpaHtmlOnly && app.isFallback(requestedPath) {
+		util.AddVary(w.Header(), "Accept")
+		if !app.isNavigation(r) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+	}
+
+	if requestedPath == path.Clean(app.params.Directory) && app.fileType(path.Join(requestedPath, "index.html")) != util.FileTypeFile && (!app.params.SpaMode || app.fileType(app.fallbackPath()) != util.FileTypeFile) {
+		app.writeRootNoIndex(w)
+		return
+	}
+
 	responseItem, errorCode := app.GetOrCreateResponseItem(requestedPath, None, nil)
 	if errorCode != 0 {
 		w.WriteHeader(errorCode)
 		return
 	}
 
+	status := http.StatusOK
+	if app.params.FallbackStatus != 0 && app.isFallback(requestedPath) {
+		status = app.params.FallbackStatus
+	}
+
+	// browsers wait on the SPA shell, let them preload its bundles meanwhile. HTTP/1.1
+	// clients are left out, some of them take a 1xx for the final response
+	if len(app.params.EarlyHints) > 0 && r.ProtoMajor >= 2 && r.Method == http.MethodGet && responseItem.Path == path.Join(app.params.Directory, "index.html") {
+		for _, link := range app.params.EarlyHints {
+			w.Header().Add("Link", link)
+		}
+		w.WriteHeader(http.StatusEarlyHints)
+	}
+
+	if app.params.CommitHeader != "" && app.params.Commit != "" && path.Ext(responseItem.Name) == ".html" {
+		w.Header().Set(app.params.CommitHeader, app.params.Commit)
+	}
+
+	// the body is served as is, pages pick the nonce up client-side or from a meta tag
+	if app.params.ContentSecurityPolicy != "" && path.Ext(responseItem.Name) == ".html" {
+		w.Header().Set("Content-Security-Policy", strings.ReplaceAll(app.params.ContentSecurityPolicy, "{nonce}", cspNonce()))
+	}
+
+	// http.ServeContent answers unknown range units with 416, they must be ignored
+	if rangeHeader := r.Header.Get("Range"); rangeHeader != "" && !util.IsByteRange(rangeHeader) {
+		r.Header.Del("Range")
+	}
+
+	// a failed If-Range means the full content is served, so the range cannot be unsatisfiable
+	if rangeHeader := r.Header.Get("Range"); status == http.StatusOK && rangeHeader != "" && util.IfRangeMatches(r.Header.Get("If-Range"), responseItem.ETag, responseItem.ModTime) && !util.RangeSatisfiable(rangeHeader, int64(len(responseItem.Content))) {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", len(responseItem.Content)))
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	// http.ServeContent would send overlapping ranges as separate parts
+	if rangeHeader := r.Header.Get("Range"); rangeHeader != "" {
+		r.Header.Set("Range", util.CoalesceRanges(rangeHeader, int64(len(responseItem.Content))))
+	}
+
+	// the SPA fallback serves index.html for a path that has no file of its own
+	if app.params.LogFileModTime && !app.isFallback(requestedPath) {
+		util.AddLogAttrs(r, slog.String("fileModTime", responseItem.ModTime.UTC().Format(time.RFC3339)))
+	}
+
 	if r.Header.Get("Range") != "" || app.ShouldSkipCompression(requestedPath) {
 		if responseItem.ContentType != "" {
 			w.Header().Set("Content-Type", responseItem.ContentType)
 		}
-		http.ServeContent(w, r, responseItem.Name, responseItem.ModTime, bytes.NewReader(responseItem.Content))
+		if responseItem.ETag != "" {
+			w.Header().Set("ETag", responseItem.ETag)
+		}
+		app.applyDirectoryConfigs(w, responseItem.Path)
+		if app.params.LogCompressionSource {
+			util.AddLogAttrs(r, slog.String("compressionSource", CompressionSourceNone))
+		}
+		if app.params.LogCompressionDecision {
+			eligible, decision := true, CompressionDecisionRange
+			if app.ShouldSkipCompression(requestedPath) {
+				eligible, decision = false, CompressionDecisionIneligible
+			}
+			util.AddLogAttrs(r, app.compressionDecision(w, r, len(responseItem.Content), eligible, decision))
+		}
+		app.serveContent(w, r, responseItem, status)
 		return
 	}
 
-	if slices.Contains(app.params.IgnoreCacheControlPaths, r.URL.Path) || path.Ext(responseItem.Name) == ".html" {
-		w.Header().Set("Cache-Control", "no-store")
-	} else {
-		w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", app.params.CacheControlMaxAge))
+	cacheRule, cacheControl := app.CacheControl(r.URL.Path, responseItem.Name)
+	w.Header().Set("Cache-Control", cacheControl)
+	if app.applyDirectoryConfigs(w, responseItem.Path) && w.Header().Get("Cache-Control") != cacheControl {
+		cacheRule, cacheControl = CacheRuleDirectoryConfig, w.Header().Get("Cache-Control")
+	}
+	if app.params.LogCacheRule {
+		util.AddLogAttrs(r, slog.String("cacheRule", cacheRule), slog.String("cacheControl", cacheControl))
 	}
 
-	var brotliApplicable bool
-	var gzipApplicable bool
-	var overThreshold = int64(len(responseItem.Content)) > app.params.Threshold
-
-	acceptEncodingHeader := r.Header.Get("Accept-Encoding")
-	if app.params.Brotli || app.params.Gzip {
-		if strings.Contains(acceptEncodingHeader, "*") {
-			brotliApplicable = app.params.Brotli
-			gzipApplicable = app.params.Gzip
-		} else {
-			brotliApplicable = app.params.Brotli && strings.Contains(acceptEncodingHeader, "br")
-			gzipApplicable = app.params.Gzip && strings.Contains(acceptEncodingHeader, "gzip")
+	size := len(responseItem.Content)
+	if int64(size) > app.params.Threshold && (app.params.Brotli || app.params.Gzip) {
+		util.AddVary(w.Header(), "Accept-Encoding")
+		if len(app.params.BrotliDenyUserAgents) > 0 {
+			// caches must not hand a br variant to a denied client
+			util.AddVary(w.Header(), "User-Agent")
+		}
+		acceptEncoding := util.ParseAcceptEncoding(r.Header.Get("Accept-Encoding"))
+		for _, encoding := range acceptEncoding.Preferred(app.requestEncodings(r)) {
+			compressedResponseItem, _ := app.GetOrCreateResponseItem(responseItem.Path, encodingCompressions[encoding], &responseItem.ContentType)
 
+			if compressedResponseItem != nil {
+				responseItem = compressedResponseItem
+				w.Header().Set("Content-Encoding", encoding)
+				break
+			}
 		}
 	}
 
-	if brotliApplicable && overThreshold {
-		brotliResponseItem, _ := app.GetOrCreateResponseItem(responseItem.Path, Brotli, &responseItem.ContentType)
-
-		if brotliResponseItem != nil {
-			responseItem = brotliResponseItem
-			w.Header().Set("Content-Encoding", "br")
+	if app.params.LogCompressionDecision {
+		decision := w.Header().Get("Content-Encoding")
+		switch {
+		case decision != "":
+		case !app.params.Brotli && !app.params.Gzip:
+			decision = CompressionDecisionDisabled
+		case int64(size) <= app.params.Threshold:
+			decision = CompressionDecisionBelowThreshold
+		case len(util.ParseAcceptEncoding(r.Header.Get("Accept-Encoding")).Preferred(app.requestEncodings(r))) == 0:
+			decision = CompressionDecisionNotAccepted
+		default:
+			decision = CompressionDecisionNoVariant
 		}
-	} else if gzipApplicable && overThreshold {
-		gzipResponseItem, _ := app.GetOrCreateResponseItem(responseItem.Path, Gzip, &responseItem.ContentType)
+		util.AddLogAttrs(r, app.compressionDecision(w, r, size, true, decision))
+	}
 
-		if gzipResponseItem != nil {
-			responseItem = gzipResponseItem
-			w.Header().Set("Content-Encoding", "gzip")
+	if app.params.LogCompressionSource {
+		if w.Header().Get("Content-Encoding") != "" {
+			util.AddLogAttrs(r, slog.String("compressionSource", CompressionSourceDisk))
+		} else {
+			util.AddLogAttrs(r, slog.Any("compressionSource", runtimeCompressionSource{w.Header()}))
 		}
 	}
 
 	if responseItem.ContentType != "" {
 		w.Header().Set("Content-Type", responseItem.ContentType)
 	}
+	if responseItem.ETag != "" {
+		w.Header().Set("ETag", responseItem.ETag)
+	}
 
-	http.ServeContent(w, r, responseItem.Name, responseItem.ModTime, bytes.NewReader(responseItem.Content))
+	// http.ServeContent omits Content-Length once Content-Encoding is set, but the
+	// compressed body is already in memory so its length is known
+	if w.Header().Get("Content-Encoding") != "" {
+		w.Header().Set("Content-Length", strconv.Itoa(len(responseItem.Content)))
+	}
+
+	app.serveContent(w, r, responseItem, status)
+}
+
+// ConnContext applies the configured socket buffer sizes to accepted connections
+func (app *App) ConnContext(ctx context.Context, c net.Conn) context.Context {
+	if tcpConn, ok := c.(*net.TCPConn); ok {
+		if app.params.ReadBufferSize > 0 {
+			_ = tcpConn.SetReadBuffer(app.params.ReadBufferSize)
+		}
+		if app.params.WriteBufferSize > 0 {
+			_ = tcpConn.SetWriteBuffer(app.params.WriteBufferSize)
+		}
+	}
+	return ctx
+}
+
+// Handler is HandlerFuncNew wrapped in the configured middlewares, as served by Listen
+func (app *App) Handler() http.Handler {
+	// preflights are answered past the host and user agent checks but before
+	// auth, which browsers do not send them with
+	cors := util.CORSHandler(http.HandlerFunc(app.serveRequest), &app.params.CORS)
+	var handlerFunc http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.answerEarly(w, r) {
+			return
+		}
+		cors.ServeHTTP(w, r)
+	})
+	handlerFunc = util.SecurityHeadersHandler(handlerFunc, app.params.SecurityHeaders)
+	if app.params.CompressResponses {
+		// pre-compressed variants already carry a Content-Encoding and are passed through
+		compressOptions := &util.CompressOptions{
+			Threshold:                 int(app.params.Threshold),
+			BrotliQuality:             app.params.BrotliQuality,
+			MaxConcurrentCompressions: app.params.CompressMaxConcurrent,
+			Encodings:                 app.params.EncodingPreference,
+		}
+		if len(app.params.BrotliDenyUserAgents) > 0 {
+			compressOptions.BrotliDenied = app.BrotliDenied
+		}
+		handlerFunc = util.CompressHandler(handlerFunc, compressOptions)
+	}
+	return util.LogRequestHandler(handlerFunc, app.logOptions())
+}
+
+func (app *App) logOptions() *util.LogRequestHandlerOptions {
+	return &util.LogRequestHandlerOptions{
+		Disabled:          !app.params.Logger,
+		Metrics:           app.metrics,
+		Pretty:            app.params.LogPretty,
+		Format:            app.params.LogFormat,
+		MinDuration:       app.params.LogMinDuration,
+		SampleRate:        app.params.LogSampleRate,
+		RemotePort:        app.params.LogRemotePort,
+		TrustedProxies:    app.params.TrustedProxies,
+		HeaderAttrs:       app.params.LogHeaderAttrs,
+		RedactQueryParams: app.params.LogRedactQueryParams,
+		MaxQueryLength:    app.params.MaxQueryLength,
+		MessageKey:        app.params.LogMessageKey,
+		Message:           app.params.LogMessage,
+		TraceFormats:      app.params.LogTraceFormats,
+		InstanceID:        app.params.InstanceID,
+		StaticFields:      app.params.LogStaticFields,
+		RequestID:         app.params.RequestID,
+		RequestIDHeader:   app.params.RequestIDHeader,
+		SkipPaths:         app.params.LogSkipPaths,
+		SkipPrefixes:      app.params.LogSkipPrefixes,
+		Redirects:         app.params.LogRedirects,
+		DurationUnit:      app.params.LogDurationUnit,
+		Level:             app.params.LogLevel,
+	}
+}
+
+// RedirectHandler answers plain http requests with a redirect to httpsPort,
+// logged and host-checked like the requests of the main server
+func (app *App) RedirectHandler(httpsPort int) http.Handler {
+	redirect := util.HTTPSRedirectHandler(httpsPort)
+	return util.LogRequestHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.IsHostAllowed(r) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		redirect.ServeHTTP(w, r)
+	}), app.logOptions())
+}
+
+// Addr is the address the server is bound to, with the actual port when
+// listening on port 0, nil before Serve
+func (app *App) Addr() net.Addr {
+	return app.addr
 }
 
 func (app *App) Listen() {
-	var handlerFunc http.Handler = http.HandlerFunc(app.HandlerFuncNew)
-	if app.params.Logger {
-		handlerFunc = util.LogRequestHandler(handlerFunc, &util.LogRequestHandlerOptions{
-			Pretty: app.params.LogPretty,
-		})
+	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", app.params.Address, app.params.Port))
+	if err != nil {
+		panic(err)
 	}
 
-	app.server = &http.Server{
-		Addr:    fmt.Sprintf("%s:%d", app.params.Address, app.params.Port),
-		Handler: handlerFunc,
+	var redirectServer *http.Server
+	redirectErr := make(chan error, 1)
+	if app.params.TLS && app.params.HTTPSRedirectPort != 0 {
+		redirectListener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", app.params.Address, app.params.HTTPSRedirectPort))
+		if err != nil {
+			panic(err)
+		}
+		// the bound port, Port may be 0
+		httpsPort := listener.Addr().(*net.TCPAddr).Port
+		redirectServer = &http.Server{
+			Addr:    redirectListener.Addr().String(),
+			Handler: app.RedirectHandler(httpsPort),
+		}
+		fmt.Printf("Redirecting http://%s to https\n", redirectServer.Addr)
+		go func() {
+			// a failed redirect server takes the main one down, Listen then reports its error
+			if err := redirectServer.Serve(redirectListener); err != http.ErrServerClosed {
+				redirectErr <- err
+				listener.Close()
+			}
+		}()
 	}
 
-	fmt.Printf("Server listening on http://%s\n", app.server.Addr)
-	err := app.server.ListenAndServe()
+	err = app.Serve(listener)
+	if redirectServer != nil {
+		redirectServer.Close()
+	}
+	select {
+	case err = <-redirectErr:
+	default:
+	}
 	if err != nil {
 		panic(err)
 	}
 }
+
+// Serve serves requests accepted by listener until the server fails or is closed
+func (app *App) Serve(listener net.Listener) error {
+	app.addr = listener.Addr()
+	app.server = &http.Server{
+		Addr:    app.addr.String(),
+		Handler: app.Handler(),
+		// let HandlerFuncNew answer "OPTIONS *" with an Allow header
+		DisableGeneralOptionsHandler: true,
+		ConnContext:                  app.ConnContext,
+	}
+
+	if app.params.TLS {
+		config, err := app.TLSConfig()
+		if err != nil {
+			return err
+		}
+		app.server.TLSConfig = config
+		fmt.Printf("Server listening on https://%s\n", app.addr)
+		return app.server.ServeTLS(listener, "", "")
+	}
+	fmt.Printf("Server listening on http://%s\n", app.addr)
+	return app.server.Serve(listener)
+}
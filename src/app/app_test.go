@@ -3,15 +3,35 @@ package app_test
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
 	"errors"
+	"fmt"
 	"go-http-server/app"
 	"go-http-server/param"
+	"go-http-server/util"
 	"io/ioutil"
+	"log/slog"
+	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
 	"os"
+	"path/filepath"
 	"reflect"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 
 	"bou.ke/monkey"
 	"github.com/andybalholm/brotli"
@@ -96,8 +116,10 @@ func TestCompressFiles(t *testing.T) {
 		params.Directory = "../app"
 		app5 := app.NewApp(&params)
 		app5.CompressFiles()
-		os.Remove("app_test.go.br")
-		os.Remove("app.go.br")
+		brFiles, _ := filepath.Glob("*.br")
+		for _, brFile := range brFiles {
+			os.Remove(brFile)
+		}
 
 		params.Directory = "../../test/frontend/dist/vite.svg.br"
 		app6 := app.NewApp(&params)
@@ -296,7 +318,7 @@ func TestListen(t *testing.T) {
 	a := app.NewApp(&params)
 
 	var l *http.Server
-	monkey.PatchInstanceMethod(reflect.TypeOf(l), "ListenAndServe", func(*http.Server) error {
+	monkey.PatchInstanceMethod(reflect.TypeOf(l), "Serve", func(*http.Server, net.Listener) error {
 		return http.ErrServerClosed
 	})
 
@@ -309,6 +331,30 @@ func TestListen(t *testing.T) {
 	a.Listen()
 }
 
+func TestGetFilePathCacheCanonical(t *testing.T) {
+	params := param.Params{
+		Address:            "0.0.0.0",
+		Port:               8080,
+		Threshold:          1024,
+		Directory:          "../../test/frontend/dist",
+		CacheControlMaxAge: 604800,
+		SpaMode:            true,
+		CacheEnabled:       true,
+		CacheBuffer:        50 * 1024,
+	}
+	app1 := app.NewApp(&params)
+
+	for _, urlPath := range []string{"/vite.svg", "/x/../vite.svg", "/x/y/../../vite.svg", "//vite.svg", "/./vite.svg"} {
+		requestedPath, valid := app1.GetFilePath(urlPath)
+		if !valid || filepath.Base(requestedPath) != "vite.svg" {
+			t.Errorf("%s: expected vite.svg, got %q %v", urlPath, requestedPath, valid)
+		}
+	}
+	if cached := app1.CachedFilePaths(); cached != 1 {
+		t.Errorf("Expected a single cached path for the spellings of /vite.svg, got %d", cached)
+	}
+}
+
 func TestGetFilePath(t *testing.T) {
 	params := param.Params{
 		Address:                 "0.0.0.0",
@@ -345,3 +391,1846 @@ func TestGetFilePath(t *testing.T) {
 		t.Errorf("Expected false, got %t", valid)
 	}
 }
+
+func TestCommitHeader(t *testing.T) {
+	params := param.Params{
+		Address:            "0.0.0.0",
+		Port:               8080,
+		Threshold:          1024,
+		Directory:          "../../test/frontend/dist",
+		CacheControlMaxAge: 604800,
+		SpaMode:            true,
+		CacheEnabled:       true,
+		CacheBuffer:        50 * 1024,
+		CommitHeader:       "X-App-Commit",
+		Commit:             "3f2a1b9",
+	}
+	app1 := app.NewApp(&params)
+
+	req1, _ := http.NewRequest("GET", "/", nil)
+	recorder1 := httptest.NewRecorder()
+	app1.HandlerFuncNew(recorder1, req1)
+	if recorder1.Header().Get("X-App-Commit") != "3f2a1b9" {
+		t.Errorf("Expected X-App-Commit = 3f2a1b9, got %s", recorder1.Header().Get("X-App-Commit"))
+	}
+
+	req2, _ := http.NewRequest("GET", "/vite.svg", nil)
+	recorder2 := httptest.NewRecorder()
+	app1.HandlerFuncNew(recorder2, req2)
+	if recorder2.Header().Get("X-App-Commit") != "" {
+		t.Errorf("Expected no X-App-Commit on non-HTML response, got %s", recorder2.Header().Get("X-App-Commit"))
+	}
+}
+
+func TestMalformedRange(t *testing.T) {
+	params := param.Params{
+		Address:            "0.0.0.0",
+		Port:               8080,
+		Threshold:          1024,
+		Directory:          "../../test/frontend/dist",
+		CacheControlMaxAge: 604800,
+		SpaMode:            true,
+		CacheEnabled:       true,
+		CacheBuffer:        50 * 1024,
+	}
+	app1 := app.NewApp(&params)
+	vite_content, _ := ioutil.ReadFile("../../test/frontend/dist/vite.svg")
+	contentRange := fmt.Sprintf("bytes */%d", len(vite_content))
+
+	for _, rangeHeader := range []string{"bytes=abc-", "bytes=9-3", "bytes=999999-", "bytes=999999-,1000000-"} {
+		req, _ := http.NewRequest("GET", "/vite.svg", nil)
+		req.Header.Set("Range", rangeHeader)
+		recorder := httptest.NewRecorder()
+		app1.HandlerFuncNew(recorder, req)
+		if recorder.Code != http.StatusRequestedRangeNotSatisfiable {
+			t.Errorf("Range %s: expected status 416, got %d", rangeHeader, recorder.Code)
+		}
+		if recorder.Header().Get("Content-Range") != contentRange {
+			t.Errorf("Range %s: expected Content-Range = %s, got %s", rangeHeader, contentRange, recorder.Header().Get("Content-Range"))
+		}
+	}
+
+	req, _ := http.NewRequest("GET", "/vite.svg", nil)
+	req.Header.Set("Range", "bytes=0-9")
+	recorder := httptest.NewRecorder()
+	app1.HandlerFuncNew(recorder, req)
+	if recorder.Code != http.StatusPartialContent {
+		t.Errorf("Expected status 206, got %d", recorder.Code)
+	}
+	if recorder.Body.String() != string(vite_content[:10]) {
+		t.Errorf("Expected first 10 bytes of vite.svg, got %s", recorder.Body)
+	}
+
+	// overlapping ranges are merged into a single part
+	req, _ = http.NewRequest("GET", "/vite.svg", nil)
+	req.Header.Set("Range", "bytes=5-9,0-6")
+	recorder = httptest.NewRecorder()
+	app1.HandlerFuncNew(recorder, req)
+	if recorder.Code != http.StatusPartialContent || recorder.Header().Get("Content-Range") != fmt.Sprintf("bytes 0-9/%d", len(vite_content)) {
+		t.Errorf("Expected the merged range 0-9, got %d %s", recorder.Code, recorder.Header().Get("Content-Range"))
+	}
+	if recorder.Body.String() != string(vite_content[:10]) {
+		t.Errorf("Expected first 10 bytes of vite.svg, got %s", recorder.Body)
+	}
+}
+
+func TestOptionsAsterisk(t *testing.T) {
+	params := param.Params{
+		Address:            "0.0.0.0",
+		Port:               8080,
+		Threshold:          1024,
+		Directory:          "../../test/frontend/dist",
+		CacheControlMaxAge: 604800,
+		SpaMode:            true,
+		CacheEnabled:       true,
+		CacheBuffer:        50 * 1024,
+	}
+	app1 := app.NewApp(&params)
+
+	req := httptest.NewRequest("OPTIONS", "*", nil)
+	recorder := httptest.NewRecorder()
+	app1.HandlerFuncNew(recorder, req)
+	if recorder.Code != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %d", recorder.Code)
+	}
+	if recorder.Header().Get("Allow") != "GET, HEAD, OPTIONS" {
+		t.Errorf("Expected Allow = GET, HEAD, OPTIONS, got %s", recorder.Header().Get("Allow"))
+	}
+	if recorder.Body.Len() != 0 {
+		t.Errorf("Expected empty body, got %s", recorder.Body)
+	}
+}
+
+func TestImmutablePattern(t *testing.T) {
+	params := param.Params{
+		Address:            "0.0.0.0",
+		Port:               8080,
+		Threshold:          1024,
+		Directory:          "../../test/frontend/dist",
+		CacheControlMaxAge: 604800,
+		SpaMode:            true,
+		CacheEnabled:       true,
+		CacheBuffer:        50 * 1024,
+		ImmutablePattern:   regexp.MustCompile(`[.-][0-9a-fA-F]{6,}\.[0-9a-zA-Z]+$`),
+	}
+	app1 := app.NewApp(&params)
+
+	req1, _ := http.NewRequest("GET", "/assets/index.795d9409.js", nil)
+	recorder1 := httptest.NewRecorder()
+	app1.HandlerFuncNew(recorder1, req1)
+	if recorder1.Header().Get("Cache-Control") != "public, max-age=31536000, immutable" {
+		t.Errorf("Expected immutable Cache-Control for hashed file, got %s", recorder1.Header().Get("Cache-Control"))
+	}
+
+	req2, _ := http.NewRequest("GET", "/vite.svg", nil)
+	recorder2 := httptest.NewRecorder()
+	app1.HandlerFuncNew(recorder2, req2)
+	if recorder2.Header().Get("Cache-Control") != "max-age=604800" {
+		t.Errorf("Expected max-age=604800 for plain file, got %s", recorder2.Header().Get("Cache-Control"))
+	}
+}
+
+func TestNoContentPaths(t *testing.T) {
+	params := param.Params{
+		Address:            "0.0.0.0",
+		Port:               8080,
+		Threshold:          1024,
+		Directory:          "../../test/frontend/dist",
+		CacheControlMaxAge: 604800,
+		SpaMode:            true,
+		CacheEnabled:       true,
+		CacheBuffer:        50 * 1024,
+		NoContentPaths:     []string{"/favicon.ico", "/vite.svg"},
+	}
+	app1 := app.NewApp(&params)
+
+	req1, _ := http.NewRequest("GET", "/favicon.ico", nil)
+	recorder1 := httptest.NewRecorder()
+	app1.HandlerFuncNew(recorder1, req1)
+	if recorder1.Code != http.StatusNoContent {
+		t.Errorf("Expected status 204 for absent /favicon.ico, got %d", recorder1.Code)
+	}
+	if recorder1.Body.Len() != 0 {
+		t.Errorf("Expected empty body, got %s", recorder1.Body)
+	}
+
+	// existing files are still served
+	vite_content, _ := ioutil.ReadFile("../../test/frontend/dist/vite.svg")
+	req2, _ := http.NewRequest("GET", "/vite.svg", nil)
+	recorder2 := httptest.NewRecorder()
+	app1.HandlerFuncNew(recorder2, req2)
+	if recorder2.Code != http.StatusOK || recorder2.Body.String() != string(vite_content) {
+		t.Errorf("Expected vite.svg to be served, got %d", recorder2.Code)
+	}
+}
+
+// newTestDir creates a temporary served directory containing the given files
+func newTestDir(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		filePath := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filePath, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestAcceptEncodingCase(t *testing.T) {
+	vite_content, _ := ioutil.ReadFile("../../test/frontend/dist/vite.svg")
+	params := param.Params{
+		Address:            "0.0.0.0",
+		Port:               8080,
+		Gzip:               true,
+		Threshold:          1024,
+		Directory:          newTestDir(t, map[string]string{"vite.svg": string(vite_content)}),
+		CacheControlMaxAge: 604800,
+		SpaMode:            true,
+		CacheEnabled:       true,
+		CacheBuffer:        50 * 1024,
+	}
+	app1 := app.NewApp(&params)
+	app1.CompressFiles()
+
+	req, _ := http.NewRequest("GET", "/vite.svg", nil)
+	req.Header.Set("Accept-Encoding", "GZIP")
+	recorder := httptest.NewRecorder()
+	app1.HandlerFuncNew(recorder, req)
+	if recorder.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Expected Content-Encoding = gzip, got %s", recorder.Header().Get("Content-Encoding"))
+	}
+	gzreader, _ := gzip.NewReader(bytes.NewReader(recorder.Body.Bytes()))
+	resp_body_decoded, _ := ioutil.ReadAll(gzreader)
+	if string(resp_body_decoded) != string(vite_content) {
+		t.Errorf("Expected vite.svg to return, got %s", recorder.Body)
+	}
+}
+
+func TestSpaHtmlOnly(t *testing.T) {
+	params := param.Params{
+		Address:            "0.0.0.0",
+		Port:               8080,
+		Threshold:          1024,
+		Directory:          "../../test/frontend/dist",
+		CacheControlMaxAge: 604800,
+		SpaMode:            true,
+		SpaHtmlOnly:        true,
+		CacheEnabled:       true,
+		CacheBuffer:        50 * 1024,
+	}
+	app1 := app.NewApp(&params)
+	index_content, _ := ioutil.ReadFile("../../test/frontend/dist/index.html")
+
+	tests := []struct {
+		path   string
+		accept string
+		code   int
+		body   string
+	}{
+		{"/dashboard/settings", "text/html,application/xhtml+xml,*/*;q=0.8", http.StatusOK, string(index_content)},
+		{"/dashboard/settings", "application/json", http.StatusNotFound, ""},
+		{"/missing.js", "text/html", http.StatusNotFound, ""},
+		{"/", "*/*", http.StatusOK, string(index_content)},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", tt.path, nil)
+		req.Header.Set("Accept", tt.accept)
+		recorder := httptest.NewRecorder()
+		app1.HandlerFuncNew(recorder, req)
+		if recorder.Code != tt.code {
+			t.Errorf("%s (%s): expected status %d, got %d", tt.path, tt.accept, tt.code, recorder.Code)
+		}
+		if recorder.Body.String() != tt.body {
+			t.Errorf("%s (%s): unexpected body %s", tt.path, tt.accept, recorder.Body)
+		}
+	}
+}
+
+func TestSpaBypassPrefixes(t *testing.T) {
+	params := param.Params{
+		Address:            "0.0.0.0",
+		Port:               8080,
+		Threshold:          1024,
+		Directory:          "../../test/frontend/dist",
+		CacheControlMaxAge: 604800,
+		SpaMode:            true,
+		SpaHtmlOnly:        true,
+		SpaBypassPrefixes:  []string{"/api"},
+		CacheEnabled:       true,
+		CacheBuffer:        50 * 1024,
+	}
+	app1 := app.NewApp(&params)
+	index_content, _ := ioutil.ReadFile("../../test/frontend/dist/index.html")
+
+	tests := []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/dashboard/settings", http.StatusOK, string(index_content)},
+		{"/apis/settings", http.StatusOK, string(index_content)},
+		{"/assets/missing.js", http.StatusNotFound, ""},
+		{"/api/foo", http.StatusNotFound, ""},
+		{"/api", http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", tt.path, nil)
+		req.Header.Set("Accept", "text/html,application/xhtml+xml,*/*;q=0.8")
+		recorder := httptest.NewRecorder()
+		app1.HandlerFuncNew(recorder, req)
+		if recorder.Code != tt.code || recorder.Body.String() != tt.body {
+			t.Errorf("%s: expected status %d, got %d", tt.path, tt.code, recorder.Code)
+		}
+	}
+}
+
+func TestSpaRouteExtensions(t *testing.T) {
+	index_content, _ := ioutil.ReadFile("../../test/frontend/dist/index.html")
+	tests := []struct {
+		spaHtmlOnly bool
+		path        string
+		code        int
+		body        string
+	}{
+		{false, "/docs/getting-started", http.StatusOK, string(index_content)},
+		{false, "/docs/legacy-page.html", http.StatusOK, string(index_content)},
+		{false, "/docs/legacy-page.HTML", http.StatusOK, string(index_content)},
+		{false, "/assets/missing.js", http.StatusNotFound, ""},
+		{false, "/assets/missing.png", http.StatusNotFound, ""},
+		{true, "/docs/legacy-page.html", http.StatusOK, string(index_content)},
+		{true, "/assets/missing.js", http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		params := param.Params{
+			Address:            "0.0.0.0",
+			Port:               8080,
+			Threshold:          1024,
+			Directory:          "../../test/frontend/dist",
+			CacheControlMaxAge: 604800,
+			SpaMode:            true,
+			SpaHtmlOnly:        tt.spaHtmlOnly,
+			SpaRouteExtensions: []string{"html"},
+			CacheEnabled:       true,
+			CacheBuffer:        50 * 1024,
+		}
+		app1 := app.NewApp(&params)
+
+		req, _ := http.NewRequest("GET", tt.path, nil)
+		req.Header.Set("Accept", "text/html,application/xhtml+xml,*/*;q=0.8")
+		recorder := httptest.NewRecorder()
+		app1.HandlerFuncNew(recorder, req)
+		if recorder.Code != tt.code || recorder.Body.String() != tt.body {
+			t.Errorf("%s (html only %v): expected status %d, got %d", tt.path, tt.spaHtmlOnly, tt.code, recorder.Code)
+		}
+	}
+}
+
+// compressed variants are served from memory, so their length is always known
+// and the response must not fall back to chunked encoding
+func TestCompressedContentLength(t *testing.T) {
+	vite_content, _ := ioutil.ReadFile("../../test/frontend/dist/vite.svg")
+	params := param.Params{
+		Address:            "0.0.0.0",
+		Port:               8080,
+		Gzip:               true,
+		Brotli:             true,
+		Threshold:          1024,
+		Directory:          newTestDir(t, map[string]string{"vite.svg": string(vite_content)}),
+		CacheControlMaxAge: 604800,
+		SpaMode:            true,
+		CacheEnabled:       true,
+		CacheBuffer:        50 * 1024,
+	}
+	app1 := app.NewApp(&params)
+	app1.CompressFiles()
+
+	for _, encoding := range []string{"br", "gzip"} {
+		req, _ := http.NewRequest("GET", "/vite.svg", nil)
+		req.Header.Set("Accept-Encoding", encoding)
+		recorder := httptest.NewRecorder()
+		app1.HandlerFuncNew(recorder, req)
+		if recorder.Header().Get("Content-Encoding") != encoding {
+			t.Errorf("Expected Content-Encoding = %s, got %s", encoding, recorder.Header().Get("Content-Encoding"))
+		}
+		if recorder.Header().Get("Content-Length") != fmt.Sprint(recorder.Body.Len()) {
+			t.Errorf("Expected Content-Length = %d for %s, got %s", recorder.Body.Len(), encoding, recorder.Header().Get("Content-Length"))
+		}
+		if recorder.Header().Get("Transfer-Encoding") != "" {
+			t.Errorf("Expected no Transfer-Encoding for %s, got %s", encoding, recorder.Header().Get("Transfer-Encoding"))
+		}
+	}
+}
+
+func TestAllowPaths(t *testing.T) {
+	params := param.Params{
+		Address:            "0.0.0.0",
+		Port:               8080,
+		Threshold:          1024,
+		Directory:          "../../test/frontend/dist",
+		CacheControlMaxAge: 604800,
+		SpaMode:            true,
+		CacheEnabled:       true,
+		CacheBuffer:        50 * 1024,
+		AllowPaths:         []string{"/", "/assets/*", "/dashboard*"},
+	}
+	app1 := app.NewApp(&params)
+	index_content, _ := ioutil.ReadFile("../../test/frontend/dist/index.html")
+	js_content, _ := ioutil.ReadFile("../../test/frontend/dist/assets/index.795d9409.js")
+
+	tests := []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/", http.StatusOK, string(index_content)},
+		{"/assets/index.795d9409.js", http.StatusOK, string(js_content)},
+		{"/dashboard/settings", http.StatusOK, string(index_content)},
+		{"/vite.svg", http.StatusNotFound, ""},
+		{"/example.html", http.StatusNotFound, ""},
+		{"/assets/../vite.svg", http.StatusNotFound, ""},
+		{"/random", http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.path, nil)
+		recorder := httptest.NewRecorder()
+		app1.HandlerFuncNew(recorder, req)
+		if recorder.Code != tt.code {
+			t.Errorf("%s: expected status %d, got %d", tt.path, tt.code, recorder.Code)
+		}
+		if recorder.Body.String() != tt.body {
+			t.Errorf("%s: unexpected body %s", tt.path, recorder.Body)
+		}
+	}
+}
+
+func TestEncodingPreference(t *testing.T) {
+	vite_content, _ := ioutil.ReadFile("../../test/frontend/dist/vite.svg")
+	params := param.Params{
+		Address:            "0.0.0.0",
+		Port:               8080,
+		Gzip:               true,
+		Brotli:             true,
+		EncodingPreference: []string{"gzip", "br"},
+		Threshold:          1024,
+		Directory:          newTestDir(t, map[string]string{"vite.svg": string(vite_content)}),
+		CacheControlMaxAge: 604800,
+		SpaMode:            true,
+		CacheEnabled:       true,
+		CacheBuffer:        50 * 1024,
+	}
+	app1 := app.NewApp(&params)
+	app1.CompressFiles()
+
+	tests := []struct {
+		acceptEncoding string
+		expected       string
+	}{
+		{"br, gzip", "gzip"},
+		{"br;q=1.0, gzip;q=0.5", "br"},
+		{"br", "br"},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", "/vite.svg", nil)
+		req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+		recorder := httptest.NewRecorder()
+		app1.HandlerFuncNew(recorder, req)
+		if recorder.Header().Get("Content-Encoding") != tt.expected {
+			t.Errorf("Accept-Encoding %s: expected Content-Encoding = %s, got %s", tt.acceptEncoding, tt.expected, recorder.Header().Get("Content-Encoding"))
+		}
+	}
+}
+
+func TestHostValidation(t *testing.T) {
+	params := param.Params{
+		Address:            "0.0.0.0",
+		Port:               8080,
+		Threshold:          1024,
+		Directory:          "../../test/frontend/dist",
+		CacheControlMaxAge: 604800,
+		SpaMode:            true,
+		CacheEnabled:       true,
+		CacheBuffer:        50 * 1024,
+	}
+	params.AllowedHosts = []string{"example.com", "www.example.com"}
+	app1 := app.NewApp(&params)
+	tests := []struct {
+		host string
+		code int
+	}{
+		{"example.com", http.StatusOK},
+		{"WWW.example.com:8080", http.StatusOK},
+		{"evil.com", http.StatusBadRequest},
+		{"example.com.evil.com", http.StatusBadRequest},
+		{"example.com.", http.StatusOK},
+		{"www.example.com.:8080", http.StatusOK},
+		{"example.com..", http.StatusBadRequest},
+		{".", http.StatusBadRequest},
+		{"", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Host = tt.host
+		recorder := httptest.NewRecorder()
+		app1.HandlerFuncNew(recorder, req)
+		if recorder.Code != tt.code {
+			t.Errorf("Host %q: expected status %d, got %d", tt.host, tt.code, recorder.Code)
+		}
+	}
+}
+
+func TestRedirectHandler(t *testing.T) {
+	params := param.Params{
+		Address:            "0.0.0.0",
+		Port:               8443,
+		Threshold:          1024,
+		Directory:          "../../test/frontend/dist",
+		CacheControlMaxAge: 604800,
+		SpaMode:            true,
+		CacheEnabled:       true,
+		CacheBuffer:        50 * 1024,
+		AllowedHosts:       []string{"example.com"},
+	}
+	app1 := app.NewApp(&params)
+	tests := []struct {
+		host     string
+		code     int
+		location string
+	}{
+		{"example.com:8080", http.StatusMovedPermanently, "https://example.com:9443/page?a=1"},
+		{"evil.com", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/page?a=1", nil)
+		req.Host = tt.host
+		recorder := httptest.NewRecorder()
+		app1.RedirectHandler(9443).ServeHTTP(recorder, req)
+		if recorder.Code != tt.code {
+			t.Errorf("Host %q: expected status %d, got %d", tt.host, tt.code, recorder.Code)
+		}
+		if recorder.Header().Get("Location") != tt.location {
+			t.Errorf("Host %q: expected Location %q, got %q", tt.host, tt.location, recorder.Header().Get("Location"))
+		}
+	}
+}
+
+func TestNoSniff(t *testing.T) {
+	params := param.Params{
+		Address:            "0.0.0.0",
+		Port:               8080,
+		Threshold:          1024,
+		Directory:          newTestDir(t, map[string]string{"page.unknownext": "<html><body>hello</body></html>"}),
+		CacheControlMaxAge: 604800,
+		SpaMode:            false,
+		CacheEnabled:       true,
+		CacheBuffer:        50 * 1024,
+	}
+
+	app1 := app.NewApp(&params)
+	req1, _ := http.NewRequest("GET", "/page.unknownext", nil)
+	recorder1 := httptest.NewRecorder()
+	app1.HandlerFuncNew(recorder1, req1)
+	if recorder1.Header().Get("Content-Type") != "text/html; charset=utf-8" {
+		t.Errorf("Expected sniffed text/html by default, got %s", recorder1.Header().Get("Content-Type"))
+	}
+
+	params.NoSniff = true
+	app2 := app.NewApp(&params)
+	req2, _ := http.NewRequest("GET", "/page.unknownext", nil)
+	recorder2 := httptest.NewRecorder()
+	app2.HandlerFuncNew(recorder2, req2)
+	if recorder2.Header().Get("Content-Type") != "application/octet-stream" {
+		t.Errorf("Expected application/octet-stream with sniffing disabled, got %s", recorder2.Header().Get("Content-Type"))
+	}
+}
+
+func TestQueryStringLookup(t *testing.T) {
+	script := strings.Repeat("console.log('cache busted');\n", 100)
+	params := param.Params{
+		Address:            "0.0.0.0",
+		Port:               8080,
+		Gzip:               true,
+		Threshold:          1024,
+		Directory:          newTestDir(t, map[string]string{"index.html": "shell", "app.js": script}),
+		CacheControlMaxAge: 604800,
+		SpaMode:            true,
+		CacheEnabled:       true,
+		CacheBuffer:        50 * 1024,
+	}
+	app1 := app.NewApp(&params)
+	app1.CompressFiles()
+
+	req1, _ := http.NewRequest("GET", "/app.js?v=123", nil)
+	recorder1 := httptest.NewRecorder()
+	app1.HandlerFuncNew(recorder1, req1)
+	if recorder1.Code != http.StatusOK || recorder1.Body.String() != script {
+		t.Errorf("Expected app.js served for a cache busted URL, got %d %q", recorder1.Code, recorder1.Body.String()[:min(recorder1.Body.Len(), 20)])
+	}
+
+	req2, _ := http.NewRequest("GET", "/app.js?v=123", nil)
+	req2.Header.Set("Accept-Encoding", "gzip")
+	recorder2 := httptest.NewRecorder()
+	app1.HandlerFuncNew(recorder2, req2)
+	if recorder2.Header().Get("Content-Encoding") != "gzip" {
+		t.Errorf("Expected the pre-compressed sibling for a cache busted URL, got %q", recorder2.Header().Get("Content-Encoding"))
+	}
+	if recorder2.Header().Get("Content-Type") != "text/javascript; charset=utf-8" {
+		t.Errorf("Expected javascript content type, got %s", recorder2.Header().Get("Content-Type"))
+	}
+}
+
+func TestFallbackMethods(t *testing.T) {
+	params := param.Params{
+		Address:            "0.0.0.0",
+		Port:               8080,
+		Threshold:          1024,
+		Directory:          newTestDir(t, map[string]string{"index.html": "shell", "app.js": "console.log()"}),
+		CacheControlMaxAge: 604800,
+		SpaMode:            true,
+		CacheEnabled:       true,
+		CacheBuffer:        50 * 1024,
+	}
+	app1 := app.NewApp(&params)
+
+	tests := []struct {
+		method string
+		path   string
+		code   int
+		body   string
+	}{
+		{"GET", "/some/route", http.StatusOK, "shell"},
+		{"HEAD", "/some/route", http.StatusOK, ""},
+		{"POST", "/some/route", http.StatusMethodNotAllowed, ""},
+		{"DELETE", "/some/route", http.StatusMethodNotAllowed, ""},
+		{"POST", "/app.js", http.StatusOK, "console.log()"},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest(tt.method, tt.path, nil)
+		recorder := httptest.NewRecorder()
+		app1.HandlerFuncNew(recorder, req)
+		if recorder.Code != tt.code || recorder.Body.String() != tt.body {
+			t.Errorf("%s %s: expected %d %q, got %d %q", tt.method, tt.path, tt.code, tt.body, recorder.Code, recorder.Body)
+		}
+		if tt.code == http.StatusMethodNotAllowed && recorder.Header().Get("Allow") != "GET, HEAD, OPTIONS" {
+			t.Errorf("%s %s: expected Allow header, got %q", tt.method, tt.path, recorder.Header().Get("Allow"))
+		}
+	}
+}
+
+func TestETagStrategies(t *testing.T) {
+	for _, strategy := range []param.ETagStrategy{param.ETagStrategyContent, param.ETagStrategyModTime} {
+		params := param.Params{
+			Address:            "0.0.0.0",
+			Port:               8080,
+			Threshold:          1024,
+			Directory:          newTestDir(t, map[string]string{"app.js": "console.log('etag')"}),
+			CacheControlMaxAge: 604800,
+			SpaMode:            false,
+			CacheEnabled:       true,
+			CacheBuffer:        50 * 1024,
+			ETag:               true,
+			ETagStrategy:       strategy,
+		}
+		app1 := app.NewApp(&params)
+
+		recorder := httptest.NewRecorder()
+		app1.HandlerFuncNew(recorder, httptest.NewRequest("GET", "/app.js", nil))
+		etag := recorder.Header().Get("ETag")
+		if recorder.Code != http.StatusOK || etag == "" || recorder.Body.String() != "console.log('etag')" {
+			t.Fatalf("%s: expected 200 with an ETag, got %d %q", strategy, recorder.Code, etag)
+		}
+		if weak := strings.HasPrefix(etag, "W/"); weak != (strategy == param.ETagStrategyModTime) {
+			t.Errorf("%s: unexpected ETag %q", strategy, etag)
+		}
+
+		req := httptest.NewRequest("GET", "/app.js", nil)
+		req.Header.Set("If-None-Match", etag)
+		recorder = httptest.NewRecorder()
+		app1.HandlerFuncNew(recorder, req)
+		if recorder.Code != http.StatusNotModified || recorder.Body.Len() != 0 {
+			t.Errorf("%s: expected 304 with an empty body, got %d %q", strategy, recorder.Code, recorder.Body.String())
+		}
+	}
+}
+
+func TestBrotliDenyUserAgents(t *testing.T) {
+	params := param.Params{
+		Address:              "0.0.0.0",
+		Port:                 8080,
+		Gzip:                 true,
+		Brotli:               true,
+		Threshold:            1024,
+		Directory:            newTestDir(t, map[string]string{"app.js": strings.Repeat("console.log('br');\n", 100)}),
+		CacheControlMaxAge:   604800,
+		SpaMode:              false,
+		CacheEnabled:         true,
+		CacheBuffer:          50 * 1024,
+		BrotliDenyUserAgents: []*regexp.Regexp{regexp.MustCompile(`OldBrowser/1\.`)},
+	}
+	app1 := app.NewApp(&params)
+	app1.CompressFiles()
+
+	tests := []struct {
+		userAgent string
+		encoding  string
+	}{
+		{"Mozilla/5.0 OldBrowser/1.2", "gzip"},
+		{"Mozilla/5.0 Firefox/130.0", "br"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/app.js", nil)
+		req.Header.Set("Accept-Encoding", "br, gzip")
+		req.Header.Set("User-Agent", tt.userAgent)
+		recorder := httptest.NewRecorder()
+		app1.Handler().ServeHTTP(recorder, req)
+		if recorder.Header().Get("Content-Encoding") != tt.encoding {
+			t.Errorf("%s: expected %s, got %q", tt.userAgent, tt.encoding, recorder.Header().Get("Content-Encoding"))
+		}
+		if vary := recorder.Header().Get("Vary"); vary != "Accept-Encoding, User-Agent" {
+			t.Errorf("%s: expected Vary on Accept-Encoding and User-Agent, got %q", tt.userAgent, vary)
+		}
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	params := param.Params{
+		Address:         "0.0.0.0",
+		Port:            8080,
+		Directory:       newTestDir(t, map[string]string{"app.js": "console.log()"}),
+		AllowedHosts:    []string{"example.com"},
+		DenyUserAgents:  []*regexp.Regexp{regexp.MustCompile(`BadBot`)},
+		SecurityHeaders: util.SecurityHeaders{XFrameOptions: "DENY"},
+		CORS:            util.CORSOptions{AllowedOrigins: []string{"https://app.example.com"}},
+	}
+	app1 := app.NewApp(&params)
+
+	tests := []struct {
+		host      string
+		userAgent string
+		code      int
+	}{
+		{"example.com", "Mozilla/5.0", http.StatusNoContent},
+		{"evil.example", "Mozilla/5.0", http.StatusBadRequest},
+		{"example.com", "BadBot/1.0", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("OPTIONS", "/app.js", nil)
+		req.Host = tt.host
+		req.Header.Set("User-Agent", tt.userAgent)
+		req.Header.Set("Origin", "https://app.example.com")
+		req.Header.Set("Access-Control-Request-Method", "GET")
+		recorder := httptest.NewRecorder()
+		app1.Handler().ServeHTTP(recorder, req)
+		if recorder.Code != tt.code {
+			t.Errorf("%s %s: expected %d, got %d", tt.host, tt.userAgent, tt.code, recorder.Code)
+		}
+		if allowOrigin := recorder.Header().Get("Access-Control-Allow-Origin"); (tt.code == http.StatusNoContent) != (allowOrigin != "") {
+			t.Errorf("%s %s: unexpected Access-Control-Allow-Origin %q", tt.host, tt.userAgent, allowOrigin)
+		}
+		if recorder.Header().Get("X-Frame-Options") != "DENY" {
+			t.Errorf("%s %s: expected the security headers on the preflight response", tt.host, tt.userAgent)
+		}
+	}
+}
+
+func TestETagPerEncoding(t *testing.T) {
+	params := param.Params{
+		Address:            "0.0.0.0",
+		Port:               8080,
+		Gzip:               true,
+		Brotli:             true,
+		Threshold:          1024,
+		Directory:          newTestDir(t, map[string]string{"app.js": strings.Repeat("console.log('etag');\n", 100)}),
+		CacheControlMaxAge: 604800,
+		SpaMode:            false,
+		CacheEnabled:       true,
+		CacheBuffer:        50 * 1024,
+		ETag:               true,
+	}
+	app1 := app.NewApp(&params)
+	app1.CompressFiles()
+
+	etags := map[string]string{}
+	for _, encoding := range []string{"identity", "gzip", "br"} {
+		req, _ := http.NewRequest("GET", "/app.js", nil)
+		req.Header.Set("Accept-Encoding", encoding)
+		recorder := httptest.NewRecorder()
+		app1.HandlerFuncNew(recorder, req)
+		etag := recorder.Header().Get("ETag")
+		if etag == "" {
+			t.Fatalf("Expected an ETag for %s", encoding)
+		}
+		etags[encoding] = etag
+
+		// conditional requests match against the tag of the same representation
+		req2, _ := http.NewRequest("GET", "/app.js", nil)
+		req2.Header.Set("Accept-Encoding", encoding)
+		req2.Header.Set("If-None-Match", etag)
+		recorder2 := httptest.NewRecorder()
+		app1.HandlerFuncNew(recorder2, req2)
+		if recorder2.Code != http.StatusNotModified {
+			t.Errorf("Expected 304 for a matching %s ETag, got %d", encoding, recorder2.Code)
+		}
+	}
+
+	if etags["identity"] == etags["gzip"] || etags["gzip"] == etags["br"] || etags["identity"] == etags["br"] {
+		t.Errorf("Expected distinct ETags per encoding, got %v", etags)
+	}
+
+	req, _ := http.NewRequest("GET", "/app.js", nil)
+	req.Header.Set("Accept-Encoding", "br")
+	req.Header.Set("If-None-Match", etags["gzip"])
+	recorder := httptest.NewRecorder()
+	app1.HandlerFuncNew(recorder, req)
+	if recorder.Code != http.StatusOK {
+		t.Errorf("Expected 200 when the ETag of another encoding is sent, got %d", recorder.Code)
+	}
+}
+
+// BenchmarkHandlerCachedCompressed covers the hot path: a browser GET for a
+// cached asset with a pre-compressed variant
+func BenchmarkHandlerCachedCompressed(b *testing.B) {
+	dir := b.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "app.js"), bytes.Repeat([]byte("console.log('hot path');\n"), 200), 0o644); err != nil {
+		b.Fatal(err)
+	}
+	params := param.Params{
+		Address:            "0.0.0.0",
+		Port:               8080,
+		Gzip:               true,
+		Brotli:             true,
+		Threshold:          1024,
+		Directory:          dir,
+		CacheControlMaxAge: 604800,
+		SpaMode:            true,
+		CacheEnabled:       true,
+		CacheBuffer:        50 * 1024,
+	}
+	app1 := app.NewApp(&params)
+	app1.CompressFiles()
+
+	req := httptest.NewRequest("GET", "/app.js", nil)
+	req.Header.Set("Accept-Encoding", "gzip, deflate, br, zstd")
+	req.Header.Set("Accept", "*/*")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		recorder := httptest.NewRecorder()
+		app1.HandlerFuncNew(recorder, req)
+		if recorder.Code != http.StatusOK {
+			b.Fatalf("Expected status 200, got %d", recorder.Code)
+		}
+	}
+}
+
+func TestCompressFilesMissingVariants(t *testing.T) {
+	large := strings.Repeat("console.log('compress me');\n", 100)
+	dir := newTestDir(t, map[string]string{
+		"app.js":       large,
+		"vendor.js":    large,
+		"vendor.js.br": "from the build pipeline",
+		"small.js":     "console.log()",
+		"photo.png":    large,
+	})
+	params := param.Params{
+		Address:            "0.0.0.0",
+		Port:               8080,
+		Brotli:             true,
+		Threshold:          1024,
+		NoCompress:         []string{".png"},
+		Directory:          dir,
+		CacheControlMaxAge: 604800,
+		SpaMode:            false,
+		CacheEnabled:       true,
+		CacheBuffer:        50 * 1024,
+	}
+	past := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "vendor.js"), past, past); err != nil {
+		t.Fatal(err)
+	}
+	app1 := app.NewApp(&params)
+	app1.CompressFiles()
+
+	if _, err := os.Stat(filepath.Join(dir, "app.js.br")); err != nil {
+		t.Errorf("Expected app.js.br to be generated: %v", err)
+	}
+	for _, skipped := range []string{"small.js.br", "photo.png.br"} {
+		if _, err := os.Stat(filepath.Join(dir, skipped)); err == nil {
+			t.Errorf("Expected no %s below the threshold or for excluded extensions", skipped)
+		}
+	}
+	if content, _ := os.ReadFile(filepath.Join(dir, "vendor.js.br")); string(content) != "from the build pipeline" {
+		t.Errorf("Expected the existing vendor.js.br to be kept, got %q", content)
+	}
+
+	// a source newer than its variant gets the variant regenerated
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "vendor.js"), future, future); err != nil {
+		t.Fatal(err)
+	}
+	app1.CompressFiles()
+	if content, _ := os.ReadFile(filepath.Join(dir, "vendor.js.br")); string(content) == "from the build pipeline" {
+		t.Errorf("Expected a stale vendor.js.br to be regenerated")
+	}
+}
+
+func TestIfRange(t *testing.T) {
+	dir := newTestDir(t, map[string]string{"video.bin": "0123456789"})
+	modTime := time.Date(2024, 5, 1, 12, 30, 45, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(dir, "video.bin"), modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	params := param.Params{
+		Address:            "0.0.0.0",
+		Port:               8080,
+		Threshold:          1024,
+		Directory:          dir,
+		CacheControlMaxAge: 604800,
+		SpaMode:            false,
+		CacheEnabled:       true,
+		CacheBuffer:        50 * 1024,
+		ETag:               true,
+	}
+	app1 := app.NewApp(&params)
+
+	req, _ := http.NewRequest("GET", "/video.bin", nil)
+	recorder := httptest.NewRecorder()
+	app1.HandlerFuncNew(recorder, req)
+	etag := recorder.Header().Get("ETag")
+
+	tests := []struct {
+		name    string
+		rng     string
+		ifRange string
+		code    int
+		body    string
+	}{
+		{"matching etag", "bytes=2-4", etag, http.StatusPartialContent, "234"},
+		{"matching date", "bytes=2-4", modTime.Format(http.TimeFormat), http.StatusPartialContent, "234"},
+		{"changed etag", "bytes=2-4", `"stale"`, http.StatusOK, "0123456789"},
+		{"changed date", "bytes=2-4", modTime.Add(-time.Hour).Format(http.TimeFormat), http.StatusOK, "0123456789"},
+		{"unsatisfiable range with changed etag", "bytes=20-30", `"stale"`, http.StatusOK, "0123456789"},
+		{"unsatisfiable range with matching etag", "bytes=20-30", etag, http.StatusRequestedRangeNotSatisfiable, ""},
+		{"partly satisfiable ranges", "bytes=0-4,20-30", etag, http.StatusPartialContent, "01234"},
+		{"unknown range unit", "items=0-1", etag, http.StatusOK, "0123456789"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", "/video.bin", nil)
+			req.Header.Set("Range", tt.rng)
+			req.Header.Set("If-Range", tt.ifRange)
+			recorder := httptest.NewRecorder()
+			app1.HandlerFuncNew(recorder, req)
+			if recorder.Code != tt.code || recorder.Body.String() != tt.body {
+				t.Errorf("Expected %d %q, got %d %q", tt.code, tt.body, recorder.Code, recorder.Body)
+			}
+		})
+	}
+}
+
+func TestPercentEncodedPaths(t *testing.T) {
+	root := t.TempDir()
+	for name, content := range map[string]string{"www/my file.js": "spaced", "www-private/secret.txt": "secret"} {
+		filePath := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(filePath), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filePath, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	params := param.Params{
+		Address:            "0.0.0.0",
+		Port:               8080,
+		Threshold:          1024,
+		Directory:          filepath.Join(root, "www"),
+		CacheControlMaxAge: 604800,
+		SpaMode:            false,
+		CacheEnabled:       true,
+		CacheBuffer:        50 * 1024,
+	}
+	app1 := app.NewApp(&params)
+
+	tests := []struct {
+		target string
+		code   int
+		body   string
+	}{
+		{"/my%20file.js", http.StatusOK, "spaced"},
+		{"/..%2fwww-private%2fsecret.txt", http.StatusNotFound, ""},
+		{"/%2e%2e/www-private/secret.txt", http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.target, nil)
+		recorder := httptest.NewRecorder()
+		app1.HandlerFuncNew(recorder, req)
+		if recorder.Code != tt.code || recorder.Body.String() != tt.body {
+			t.Errorf("%s: expected %d %q, got %d %q", tt.target, tt.code, tt.body, recorder.Code, recorder.Body)
+		}
+	}
+}
+
+func TestLogCompressionDecision(t *testing.T) {
+	params := param.Params{
+		Address:   "0.0.0.0",
+		Port:      8080,
+		Gzip:      true,
+		Threshold: 1024,
+		Directory: newTestDir(t, map[string]string{
+			"app.js":   strings.Repeat("console.log('decision');\n", 100),
+			"small.js": "console.log()",
+		}),
+		CacheControlMaxAge:     604800,
+		SpaMode:                false,
+		CacheEnabled:           true,
+		CacheBuffer:            50 * 1024,
+		LogCompressionDecision: true,
+	}
+	app1 := app.NewApp(&params)
+	app1.CompressFiles()
+
+	var logs bytes.Buffer
+	handler := util.LogRequestHandler(http.HandlerFunc(app1.HandlerFuncNew), &util.LogRequestHandlerOptions{Writer: &logs})
+
+	tests := []struct {
+		target         string
+		meetsThreshold bool
+		decision       string
+	}{
+		{"/small.js", false, app.CompressionDecisionBelowThreshold},
+		{"/app.js", true, "gzip"},
+	}
+
+	for _, tt := range tests {
+		logs.Reset()
+		req := httptest.NewRequest("GET", tt.target, nil)
+		req.Header.Set("Accept-Encoding", "gzip, br")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		var logData struct {
+			Compression struct {
+				AcceptEncoding string  `json:"acceptEncoding"`
+				Eligible       bool    `json:"eligible"`
+				Size           int     `json:"size"`
+				Threshold      float64 `json:"threshold"`
+				MeetsThreshold bool    `json:"meetsThreshold"`
+				Decision       string  `json:"decision"`
+			} `json:"compression"`
+		}
+		if err := json.Unmarshal(logs.Bytes(), &logData); err != nil {
+			t.Fatalf("Failed to parse log output as JSON: %v\nLog output: %s", err, logs.String())
+		}
+		c := logData.Compression
+		if c.AcceptEncoding != "gzip, br" || !c.Eligible || c.Threshold != 1024 || c.MeetsThreshold != tt.meetsThreshold || c.Decision != tt.decision {
+			t.Errorf("%s: unexpected compression decision: %s", tt.target, logs.String())
+		}
+	}
+}
+
+func TestLogCompressionDecisionRuntime(t *testing.T) {
+	params := param.Params{
+		Address:                "0.0.0.0",
+		Port:                   8080,
+		Gzip:                   true,
+		Threshold:              1024,
+		Directory:              newTestDir(t, map[string]string{"app.js": strings.Repeat("console.log('runtime');\n", 100)}),
+		CacheControlMaxAge:     604800,
+		SpaMode:                false,
+		CacheEnabled:           true,
+		CacheBuffer:            50 * 1024,
+		LogCompressionDecision: true,
+	}
+	app1 := app.NewApp(&params)
+
+	var logs bytes.Buffer
+	handler := util.LogRequestHandler(util.CompressHandler(http.HandlerFunc(app1.HandlerFuncNew), nil), &util.LogRequestHandlerOptions{Writer: &logs})
+	req := httptest.NewRequest("GET", "/app.js", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+
+	var logData struct {
+		Compression struct {
+			Decision string `json:"decision"`
+		} `json:"compression"`
+	}
+	if err := json.Unmarshal(logs.Bytes(), &logData); err != nil {
+		t.Fatalf("Failed to parse log output as JSON: %v\nLog output: %s", err, logs.String())
+	}
+	// no pre-compressed variant, CompressResponses gzipped the response
+	if recorder.Header().Get("Content-Encoding") != "gzip" || logData.Compression.Decision != "gzip" {
+		t.Errorf("Expected the runtime encoding as decision, got %q: %s", recorder.Header().Get("Content-Encoding"), logs.String())
+	}
+}
+
+func TestLogCompressionSource(t *testing.T) {
+	params := param.Params{
+		Address:   "0.0.0.0",
+		Port:      8080,
+		Gzip:      true,
+		Threshold: 1024,
+		Directory: newTestDir(t, map[string]string{
+			"app.js":   strings.Repeat("console.log('source');\n", 100),
+			"other.js": strings.Repeat("console.log('no sibling');\n", 100),
+		}),
+		CacheControlMaxAge:   604800,
+		SpaMode:              false,
+		CacheEnabled:         true,
+		CacheBuffer:          50 * 1024,
+		LogCompressionSource: true,
+	}
+	app1 := app.NewApp(&params)
+	app1.CompressFiles()
+	os.Remove(filepath.Join(params.Directory, "other.js.gz"))
+
+	var logs bytes.Buffer
+	handler := util.LogRequestHandler(http.HandlerFunc(app1.HandlerFuncNew), &util.LogRequestHandlerOptions{Writer: &logs})
+
+	for _, target := range []string{"/app.js", "/other.js"} {
+		req := httptest.NewRequest("GET", target, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	runtime := util.LogRequestHandler(util.CompressHandler(http.HandlerFunc(app1.HandlerFuncNew), nil), &util.LogRequestHandlerOptions{Writer: &logs})
+	req := httptest.NewRequest("GET", "/other.js", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	runtime.ServeHTTP(httptest.NewRecorder(), req)
+
+	lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Expected 3 log lines, got: %s", logs.String())
+	}
+	for i, expected := range []string{app.CompressionSourceDisk, app.CompressionSourceNone, app.CompressionSourceRuntime} {
+		var logData map[string]interface{}
+		if err := json.Unmarshal([]byte(lines[i]), &logData); err != nil {
+			t.Fatalf("Failed to parse log output as JSON: %v\nLog output: %s", err, lines[i])
+		}
+		if logData["compressionSource"] != expected {
+			t.Errorf("Expected compressionSource %s, got: %s", expected, lines[i])
+		}
+	}
+}
+
+func TestLogCacheRule(t *testing.T) {
+	params := param.Params{
+		Address:   "0.0.0.0",
+		Port:      8080,
+		Threshold: 1024,
+		Directory: newTestDir(t, map[string]string{
+			"index.html":             "shell",
+			"assets/app-1a2b3c4d.js": "console.log()",
+			"assets/logo.svg":        "<svg/>",
+		}),
+		CacheControlMaxAge: 604800,
+		SpaMode:            true,
+		CacheEnabled:       true,
+		CacheBuffer:        50 * 1024,
+		ImmutablePattern:   regexp.MustCompile(`[.-][0-9a-fA-F]{6,}\.[0-9a-zA-Z]+$`),
+		LogCacheRule:       true,
+	}
+	app1 := app.NewApp(&params)
+
+	var logs bytes.Buffer
+	handler := util.LogRequestHandler(http.HandlerFunc(app1.HandlerFuncNew), &util.LogRequestHandlerOptions{Writer: &logs})
+
+	tests := []struct {
+		target       string
+		rule         string
+		cacheControl string
+	}{
+		{"/assets/app-1a2b3c4d.js", app.CacheRuleImmutable, "public, max-age=31536000, immutable"},
+		{"/assets/logo.svg", app.CacheRuleMaxAge, "max-age=604800"},
+		{"/", app.CacheRuleHTML, "no-store"},
+	}
+	for _, tt := range tests {
+		logs.Reset()
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", tt.target, nil))
+
+		var logData map[string]interface{}
+		if err := json.Unmarshal(logs.Bytes(), &logData); err != nil {
+			t.Fatalf("Failed to parse log output as JSON: %v\nLog output: %s", err, logs.String())
+		}
+		if logData["cacheRule"] != tt.rule || logData["cacheControl"] != tt.cacheControl {
+			t.Errorf("%s: expected rule %s with %q, got: %s", tt.target, tt.rule, tt.cacheControl, logs.String())
+		}
+	}
+}
+
+func TestLogFileModTime(t *testing.T) {
+	dir := newTestDir(t, map[string]string{"index.html": "shell", "app.js": "console.log()"})
+	modTime := time.Date(2024, 5, 1, 12, 30, 45, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(dir, "app.js"), modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	params := param.Params{
+		Address:            "0.0.0.0",
+		Port:               8080,
+		Threshold:          1024,
+		Directory:          dir,
+		CacheControlMaxAge: 604800,
+		SpaMode:            false,
+		CacheEnabled:       true,
+		CacheBuffer:        50 * 1024,
+		LogFileModTime:     true,
+	}
+	app1 := app.NewApp(&params)
+	params2 := params
+	params2.SpaMode = true
+	app2 := app.NewApp(&params2)
+
+	tests := []struct {
+		name     string
+		app      app.App
+		target   string
+		expected interface{}
+	}{
+		{"served file", app1, "/app.js", "2024-05-01T12:30:45Z"},
+		{"not found", app1, "/missing.js", nil},
+		{"spa fallback", app2, "/some/route", nil},
+	}
+	for _, tt := range tests {
+		var logs bytes.Buffer
+		handler := util.LogRequestHandler(http.HandlerFunc(tt.app.HandlerFuncNew), &util.LogRequestHandlerOptions{Writer: &logs})
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", tt.target, nil))
+
+		var logData map[string]interface{}
+		if err := json.Unmarshal(logs.Bytes(), &logData); err != nil {
+			t.Fatalf("Failed to parse log output as JSON: %v\nLog output: %s", err, logs.String())
+		}
+		if logData["fileModTime"] != tt.expected {
+			t.Errorf("%s: expected fileModTime %v, got: %s", tt.name, tt.expected, logs.String())
+		}
+	}
+}
+
+func TestVaryAcceptEncoding(t *testing.T) {
+	params := param.Params{
+		Address:            "0.0.0.0",
+		Port:               8080,
+		Gzip:               true,
+		Threshold:          1024,
+		Directory:          newTestDir(t, map[string]string{"index.html": strings.Repeat("<p>shell</p>\n", 100)}),
+		CacheControlMaxAge: 604800,
+		SpaMode:            true,
+		SpaHtmlOnly:        true,
+		CacheEnabled:       true,
+		CacheBuffer:        50 * 1024,
+	}
+	app1 := app.NewApp(&params)
+	app1.CompressFiles()
+
+	req := httptest.NewRequest("GET", "/dashboard", nil)
+	req.Header.Set("Accept", "text/html")
+	req.Header.Set("Accept-Encoding", "gzip")
+	recorder := httptest.NewRecorder()
+	recorder.Header().Set("Vary", "accept-encoding")
+	app1.HandlerFuncNew(recorder, req)
+	if vary := recorder.Header().Values("Vary"); len(vary) != 1 || vary[0] != "accept-encoding, Accept" {
+		t.Errorf("Expected deduplicated Vary tokens, got %q", vary)
+	}
+}
+
+func TestWasmContentType(t *testing.T) {
+	wasm := "\x00asm\x01\x00\x00\x00" + strings.Repeat("\x00", 2048)
+	params := param.Params{
+		Address:   "0.0.0.0",
+		Port:      8080,
+		Brotli:    true,
+		Threshold: 1024,
+		Directory: newTestDir(t, map[string]string{
+			"index.html":  "shell",
+			"app.wasm":    wasm,
+			"app.wasm.br": "brotli app.wasm",
+		}),
+		CacheControlMaxAge: 604800,
+		SpaMode:            true,
+		NoSniff:            true,
+		CacheEnabled:       true,
+		CacheBuffer:        50 * 1024,
+	}
+	app1 := app.NewApp(&params)
+
+	tests := []struct {
+		acceptEncoding  string
+		contentEncoding string
+		body            string
+	}{
+		{"", "", wasm},
+		{"br", "br", "brotli app.wasm"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/app.wasm", nil)
+		req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+		recorder := httptest.NewRecorder()
+		app1.HandlerFuncNew(recorder, req)
+
+		if contentType := recorder.Header().Get("Content-Type"); contentType != "application/wasm" {
+			t.Errorf("Accept-Encoding %q: expected application/wasm, got %q", tt.acceptEncoding, contentType)
+		}
+		if contentEncoding := recorder.Header().Get("Content-Encoding"); contentEncoding != tt.contentEncoding {
+			t.Errorf("Accept-Encoding %q: expected Content-Encoding %q, got %q", tt.acceptEncoding, tt.contentEncoding, contentEncoding)
+		}
+		if recorder.Body.String() != tt.body {
+			t.Errorf("Accept-Encoding %q: unexpected body %q", tt.acceptEncoding, recorder.Body.String())
+		}
+	}
+}
+
+func TestCompressMaxFiles(t *testing.T) {
+	var logs bytes.Buffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&logs, nil)))
+	defer slog.SetDefault(defaultLogger)
+
+	content := strings.Repeat("console.log()\n", 100)
+	for _, tt := range []struct {
+		maxFiles   int
+		compressed bool
+	}{
+		{2, false},
+		{3, true},
+		{0, true},
+	} {
+		logs.Reset()
+		params := param.Params{
+			Gzip:             true,
+			Threshold:        1024,
+			CompressMaxFiles: tt.maxFiles,
+			Directory:        newTestDir(t, map[string]string{"a.js": content, "b.js": content, "c/d.js": content}),
+		}
+		app1 := app.NewApp(&params)
+		app1.CompressFiles()
+
+		_, err := os.Stat(filepath.Join(params.Directory, "c/d.js.gz"))
+		if compressed := err == nil; compressed != tt.compressed {
+			t.Errorf("maxFiles %d: expected compressed %t, got %t", tt.maxFiles, tt.compressed, compressed)
+		}
+		if warned := strings.Contains(logs.String(), `"level":"WARN"`); warned == tt.compressed {
+			t.Errorf("maxFiles %d: unexpected warning output %q", tt.maxFiles, logs.String())
+		}
+	}
+}
+
+func TestEarlyHints(t *testing.T) {
+	params := param.Params{
+		Address:   "0.0.0.0",
+		Port:      8080,
+		Threshold: 1024,
+		Directory: newTestDir(t, map[string]string{
+			"index.html":    "shell",
+			"assets/app.js": "console.log()",
+		}),
+		CacheControlMaxAge: 604800,
+		SpaMode:            true,
+		CacheEnabled:       true,
+		CacheBuffer:        50 * 1024,
+		EarlyHints:         []string{"</assets/app.js>; rel=preload; as=script"},
+	}
+	app1 := app.NewApp(&params)
+
+	var logs bytes.Buffer
+	server := httptest.NewUnstartedServer(util.LogRequestHandler(http.HandlerFunc(app1.HandlerFuncNew), &util.LogRequestHandlerOptions{Writer: &logs}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	tests := []struct {
+		target string
+		hints  bool
+	}{
+		{"/", true},
+		{"/dashboard", true},
+		{"/assets/app.js", false},
+	}
+
+	for _, tt := range tests {
+		var hints []string
+		trace := &httptrace.ClientTrace{
+			Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+				if code == http.StatusEarlyHints {
+					hints = append(hints, header.Values("Link")...)
+				}
+				return nil
+			},
+		}
+		req, _ := http.NewRequestWithContext(httptrace.WithClientTrace(context.Background(), trace), "GET", server.URL+tt.target, nil)
+		resp, err := server.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if resp.ProtoMajor != 2 || resp.StatusCode != http.StatusOK {
+			t.Errorf("%s: expected a HTTP/2 200, got %s %d", tt.target, resp.Proto, resp.StatusCode)
+		}
+		if tt.hints && (len(hints) != 1 || hints[0] != "</assets/app.js>; rel=preload; as=script") {
+			t.Errorf("%s: expected preload early hints, got %q", tt.target, hints)
+		}
+		if !tt.hints && len(hints) != 0 {
+			t.Errorf("%s: expected no early hints, got %q", tt.target, hints)
+		}
+	}
+
+	if strings.Contains(logs.String(), `"code":103`) {
+		t.Errorf("Expected the final status to be logged, got: %s", logs.String())
+	}
+
+	// HTTP/1.1 clients do not get early hints
+	req := httptest.NewRequest("GET", "/", nil)
+	recorder := httptest.NewRecorder()
+	app1.HandlerFuncNew(recorder, req)
+	if recorder.Code != http.StatusOK || recorder.Header().Get("Link") != "" {
+		t.Errorf("Expected no early hints over HTTP/1.1, got %d %q", recorder.Code, recorder.Header().Get("Link"))
+	}
+}
+
+func TestPrecompressedSiblings(t *testing.T) {
+	source := strings.Repeat("console.log('bundle');\n", 100)
+	params := param.Params{
+		Address:   "0.0.0.0",
+		Port:      8080,
+		Gzip:      true,
+		Brotli:    true,
+		Threshold: 1024,
+		Directory: newTestDir(t, map[string]string{
+			"index.html":    "shell",
+			"bundle.js":     source,
+			"bundle.js.gz":  "gzip sibling",
+			"bundle.js.br":  "brotli sibling",
+			"vendor.js":     source,
+			"vendor.js.gz":  "gzip only sibling",
+			"no-sibling.js": source,
+		}),
+		CacheControlMaxAge: 604800,
+		SpaMode:            true,
+		CacheEnabled:       true,
+		CacheBuffer:        50 * 1024,
+		CompressResponses:  true,
+	}
+	app1 := app.NewApp(&params)
+
+	tests := []struct {
+		target          string
+		acceptEncoding  string
+		contentEncoding string
+		body            string
+	}{
+		{"/bundle.js", "gzip, br", "br", "brotli sibling"},
+		{"/bundle.js", "gzip", "gzip", "gzip sibling"},
+		{"/bundle.js", "br", "br", "brotli sibling"},
+		{"/bundle.js", "identity", "", source},
+		{"/vendor.js", "br", "", source},
+		{"/vendor.js", "br, gzip", "gzip", "gzip only sibling"},
+		{"/no-sibling.js", "br", "", source},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.target, nil)
+		req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+		recorder := httptest.NewRecorder()
+		app1.HandlerFuncNew(recorder, req)
+
+		if contentEncoding := recorder.Header().Get("Content-Encoding"); contentEncoding != tt.contentEncoding {
+			t.Errorf("%s with %q: expected Content-Encoding %q, got %q", tt.target, tt.acceptEncoding, tt.contentEncoding, contentEncoding)
+		}
+		if contentType := recorder.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/javascript") {
+			t.Errorf("%s with %q: expected the original content type, got %q", tt.target, tt.acceptEncoding, contentType)
+		}
+		if recorder.Body.String() != tt.body {
+			t.Errorf("%s with %q: unexpected body %q", tt.target, tt.acceptEncoding, recorder.Body.String())
+		}
+	}
+
+	// Listen wraps the handler like this with CompressResponses, siblings are not compressed again
+	handler := util.CompressHandler(http.HandlerFunc(app1.HandlerFuncNew), &util.CompressOptions{Threshold: int(params.Threshold)})
+	req := httptest.NewRequest("GET", "/vendor.js", nil)
+	req.Header.Set("Accept-Encoding", "br, gzip")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	if recorder.Header().Get("Content-Encoding") != "gzip" || recorder.Body.String() != "gzip only sibling" {
+		t.Errorf("Expected the gzip sibling as is, got %q %q", recorder.Header().Get("Content-Encoding"), recorder.Body.String())
+	}
+}
+
+func TestMaxQueryLength(t *testing.T) {
+	params := param.Params{
+		Address:            "0.0.0.0",
+		Port:               8080,
+		Threshold:          1024,
+		Directory:          newTestDir(t, map[string]string{"index.html": "shell"}),
+		CacheControlMaxAge: 604800,
+		SpaMode:            true,
+		CacheEnabled:       true,
+		CacheBuffer:        50 * 1024,
+		MaxQueryLength:     16,
+	}
+	app1 := app.NewApp(&params)
+
+	var logs bytes.Buffer
+	handler := util.LogRequestHandler(http.HandlerFunc(app1.HandlerFuncNew), &util.LogRequestHandlerOptions{Writer: &logs, MaxQueryLength: params.MaxQueryLength})
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/?utm_source="+strings.Repeat("x", 100), nil))
+	if recorder.Code != http.StatusRequestURITooLong {
+		t.Errorf("Expected 414, got %d", recorder.Code)
+	}
+
+	var logData map[string]interface{}
+	if err := json.Unmarshal(logs.Bytes(), &logData); err != nil {
+		t.Fatalf("Failed to parse log output as JSON: %v\nLog output: %s", err, logs.String())
+	}
+	if logData["query"] != "utm_source=xxxxx..." || logData["code"] != float64(http.StatusRequestURITooLong) {
+		t.Errorf("Expected a truncated query on the 414 line, got: %s", logs.String())
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/?page=2", nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("Expected 200 for a short query, got %d", recorder.Code)
+	}
+}
+
+func TestContentSecurityPolicyNonce(t *testing.T) {
+	params := param.Params{
+		Address:   "0.0.0.0",
+		Port:      8080,
+		Threshold: 1024,
+		Directory: newTestDir(t, map[string]string{
+			"index.html": `<script nonce="">boot()</script>`,
+			"app.js":     "console.log()",
+		}),
+		CacheControlMaxAge:    604800,
+		SpaMode:               true,
+		CacheEnabled:          true,
+		CacheBuffer:           50 * 1024,
+		ContentSecurityPolicy: "script-src 'self' 'nonce-{nonce}'",
+	}
+	app1 := app.NewApp(&params)
+
+	pattern := regexp.MustCompile(`^script-src 'self' 'nonce-([A-Za-z0-9+/]{22}==)'$`)
+	nonces := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		recorder := httptest.NewRecorder()
+		app1.HandlerFuncNew(recorder, httptest.NewRequest("GET", "/dashboard", nil))
+
+		match := pattern.FindStringSubmatch(recorder.Header().Get("Content-Security-Policy"))
+		if match == nil {
+			t.Fatalf("Unexpected Content-Security-Policy %q", recorder.Header().Get("Content-Security-Policy"))
+		}
+		nonces[match[1]] = true
+		if recorder.Body.String() != `<script nonce="">boot()</script>` {
+			t.Errorf("Expected the body untouched, got %q", recorder.Body.String())
+		}
+	}
+	if len(nonces) != 3 {
+		t.Errorf("Expected a fresh nonce per request, got %v", nonces)
+	}
+
+	recorder := httptest.NewRecorder()
+	app1.HandlerFuncNew(recorder, httptest.NewRequest("GET", "/app.js", nil))
+	if csp := recorder.Header().Get("Content-Security-Policy"); csp != "" {
+		t.Errorf("Expected no policy on assets, got %q", csp)
+	}
+}
+
+func TestCacheControlRules(t *testing.T) {
+	rules, err := param.ParseCacheControlRules("*.js,*.css => public, max-age=31536000, immutable;index.html => no-cache;/docs/* => max-age=60")
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := param.Params{
+		Address:   "0.0.0.0",
+		Port:      8080,
+		Threshold: 1024,
+		Directory: newTestDir(t, map[string]string{
+			"index.html":           "shell",
+			"assets/app.ab12cd.js": "console.log()",
+			"assets/logo.svg":      "<svg></svg>",
+			"docs/guide.txt":       "guide",
+		}),
+		CacheControlMaxAge: 604800,
+		SpaMode:            true,
+		CacheEnabled:       true,
+		CacheBuffer:        50 * 1024,
+		CacheControlRules:  rules,
+	}
+	app1 := app.NewApp(&params)
+
+	tests := []struct {
+		target       string
+		cacheControl string
+	}{
+		{"/assets/app.ab12cd.js", "public, max-age=31536000, immutable"},
+		{"/", "no-cache"},
+		{"/dashboard", "no-cache"},
+		{"/docs/guide.txt", "max-age=60"},
+		{"/assets/logo.svg", "max-age=604800"},
+	}
+
+	for _, tt := range tests {
+		recorder := httptest.NewRecorder()
+		app1.HandlerFuncNew(recorder, httptest.NewRequest("GET", tt.target, nil))
+		if cacheControl := recorder.Header().Get("Cache-Control"); cacheControl != tt.cacheControl {
+			t.Errorf("%s: expected Cache-Control %q, got %q", tt.target, tt.cacheControl, cacheControl)
+		}
+	}
+}
+
+func TestRootNoIndex(t *testing.T) {
+	tests := []struct {
+		status  int
+		message string
+		code    int
+		body    string
+	}{
+		{0, "", http.StatusNotFound, ""},
+		{http.StatusServiceUnavailable, "Nothing deployed yet", http.StatusServiceUnavailable, "Nothing deployed yet\n"},
+	}
+
+	for _, tt := range tests {
+		params := param.Params{
+			Address:            "0.0.0.0",
+			Port:               8080,
+			Threshold:          1024,
+			Directory:          t.TempDir(),
+			CacheControlMaxAge: 604800,
+			SpaMode:            false,
+			CacheEnabled:       true,
+			CacheBuffer:        50 * 1024,
+			RootNoIndexStatus:  tt.status,
+			RootNoIndexMessage: tt.message,
+		}
+		app1 := app.NewApp(&params)
+
+		recorder := httptest.NewRecorder()
+		app1.HandlerFuncNew(recorder, httptest.NewRequest("GET", "/", nil))
+		if recorder.Code != tt.code || recorder.Body.String() != tt.body {
+			t.Errorf("Expected %d %q, got %d %q", tt.code, tt.body, recorder.Code, recorder.Body.String())
+		}
+	}
+}
+
+func TestDenyUserAgents(t *testing.T) {
+	params := param.Params{
+		Address:            "0.0.0.0",
+		Port:               8080,
+		Threshold:          1024,
+		Directory:          newTestDir(t, map[string]string{"index.html": "shell"}),
+		CacheControlMaxAge: 604800,
+		SpaMode:            true,
+		CacheEnabled:       true,
+		CacheBuffer:        50 * 1024,
+		DenyUserAgents:     []*regexp.Regexp{regexp.MustCompile(`(?i)badbot`), regexp.MustCompile(`.*`)},
+	}
+	app1 := app.NewApp(&params)
+
+	var logs bytes.Buffer
+	handler := util.LogRequestHandler(http.HandlerFunc(app1.HandlerFuncNew), &util.LogRequestHandlerOptions{Writer: &logs})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; BadBot/2.1)")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for a denied user agent, got %d", recorder.Code)
+	}
+	var logData map[string]interface{}
+	if err := json.Unmarshal(logs.Bytes(), &logData); err != nil {
+		t.Fatalf("Failed to parse log output as JSON: %v\nLog output: %s", err, logs.String())
+	}
+	if logData["deniedUserAgent"] != "(?i)badbot" {
+		t.Errorf("Expected the matched pattern to be logged, got: %s", logs.String())
+	}
+
+	// even a catch-all pattern lets requests without a User-Agent through
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Del("User-Agent")
+	recorder = httptest.NewRecorder()
+	app1.HandlerFuncNew(recorder, req)
+	if recorder.Code != http.StatusOK {
+		t.Errorf("Expected 200 without a user agent, got %d", recorder.Code)
+	}
+
+	params2 := params
+	params2.DenyUserAgents = params.DenyUserAgents[:1]
+	app2 := app.NewApp(&params2)
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64) Firefox/130.0")
+	recorder = httptest.NewRecorder()
+	app2.HandlerFuncNew(recorder, req)
+	if recorder.Code != http.StatusOK {
+		t.Errorf("Expected 200 for a normal user agent, got %d", recorder.Code)
+	}
+}
+
+func TestFallbackFileAndStatus(t *testing.T) {
+	tests := []struct {
+		fallbackFile   string
+		fallbackStatus int
+		target         string
+		code           int
+		body           string
+	}{
+		{"", 0, "/some/route", http.StatusOK, "index"},
+		{"200.html", 0, "/some/route", http.StatusOK, "netlify"},
+		{"200.html", http.StatusNotFound, "/some/route", http.StatusNotFound, "netlify"},
+		{"200.html", http.StatusNotFound, "/", http.StatusOK, "index"},
+		{"200.html", http.StatusNotFound, "/app.js", http.StatusOK, "console.log()"},
+	}
+
+	for _, tt := range tests {
+		params := param.Params{
+			Address:            "0.0.0.0",
+			Port:               8080,
+			Threshold:          1024,
+			Directory:          newTestDir(t, map[string]string{"index.html": "index", "200.html": "netlify", "app.js": "console.log()"}),
+			CacheControlMaxAge: 604800,
+			SpaMode:            true,
+			CacheEnabled:       true,
+			CacheBuffer:        50 * 1024,
+			FallbackFile:       tt.fallbackFile,
+			FallbackStatus:     tt.fallbackStatus,
+		}
+		app1 := app.NewApp(&params)
+
+		// the second request is answered from the cache
+		for i := 0; i < 2; i++ {
+			recorder := httptest.NewRecorder()
+			app1.HandlerFuncNew(recorder, httptest.NewRequest("GET", tt.target, nil))
+			if recorder.Code != tt.code || recorder.Body.String() != tt.body {
+				t.Errorf("%q %d %s: expected %d %q, got %d %q", tt.fallbackFile, tt.fallbackStatus, tt.target, tt.code, tt.body, recorder.Code, recorder.Body.String())
+			}
+		}
+	}
+}
+
+// newTestCert writes a self-signed certificate for hosts and its key to a temp dir
+func newTestCert(t *testing.T, hosts ...string) (certFile string, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: hosts[0]},
+		DNSNames:     hosts,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestTLS(t *testing.T) {
+	certFile, keyFile := newTestCert(t, "localhost")
+	params := param.Params{
+		Address:            "127.0.0.1",
+		Port:               0,
+		Threshold:          1024,
+		Directory:          newTestDir(t, map[string]string{"index.html": "shell"}),
+		CacheControlMaxAge: 604800,
+		SpaMode:            true,
+		CacheEnabled:       true,
+		CacheBuffer:        50 * 1024,
+		TLS:                true,
+		TLSCert:            certFile,
+		TLSKey:             keyFile,
+	}
+	app1 := app.NewApp(&params)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		_ = app1.Serve(listener)
+	}()
+
+	pemData, err := os.ReadFile(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(pemData)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, ServerName: "localhost"}}}
+	res, err := client.Get("https://" + listener.Addr().String() + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	_ = res.Body.Close()
+	if res.StatusCode != http.StatusOK || string(body) != "shell" || res.TLS == nil {
+		t.Errorf("Expected 200 %q over HTTPS, got %d %q", "shell", res.StatusCode, body)
+	}
+}
+
+func TestTLSVersionLogged(t *testing.T) {
+	params := param.Params{
+		Address:            "0.0.0.0",
+		Port:               8080,
+		Threshold:          1024,
+		Directory:          newTestDir(t, map[string]string{"index.html": "shell"}),
+		CacheControlMaxAge: 604800,
+		SpaMode:            true,
+		CacheEnabled:       true,
+		CacheBuffer:        50 * 1024,
+	}
+	app1 := app.NewApp(&params)
+
+	var logs bytes.Buffer
+	server := httptest.NewTLSServer(util.LogRequestHandler(http.HandlerFunc(app1.HandlerFuncNew), &util.LogRequestHandlerOptions{Writer: &logs}))
+	res, err := server.Client().Get(server.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	_ = res.Body.Close()
+	// waits for the handler, and so the log line, to finish
+	server.Close()
+
+	if res.StatusCode != http.StatusOK || string(body) != "shell" {
+		t.Errorf("Expected 200 %q over HTTPS, got %d %q", "shell", res.StatusCode, body)
+	}
+	var logData map[string]interface{}
+	if err := json.Unmarshal(logs.Bytes(), &logData); err != nil {
+		t.Fatalf("Failed to parse log output as JSON: %v\nLog output: %s", err, logs.String())
+	}
+	if logData["tlsVersion"] != tls.VersionName(res.TLS.Version) {
+		t.Errorf("Expected the negotiated TLS version to be logged, got: %s", logs.String())
+	}
+}
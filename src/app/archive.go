@@ -0,0 +1,206 @@
+package app
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"go-http-server/util"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// OpenArchive mounts a .zip, .tar.gz or .tgz archive as a read-only file system.
+// Zip archives are read on demand, tarballs are loaded into memory once
+func OpenArchive(name string) (fs.FS, error) {
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		// kept open for the lifetime of the process
+		return zip.OpenReader(name)
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		file, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+
+		reader, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, fmt.Errorf("invalid archive %s: %w", name, err)
+		}
+		return newTarFS(reader)
+	}
+	return nil, fmt.Errorf("unsupported archive %s, expected .zip, .tar.gz or .tgz", name)
+}
+
+// ServeFS serves files from fsys instead of the served directory, which then
+// only prefixes the resolved paths. It must be called before the server starts listening
+func (app *App) ServeFS(fsys fs.FS) {
+	app.fsys = fsys
+	for i, entry := range app.healthChecks {
+		if entry.name == "directory" {
+			app.healthChecks[i].check = func() error {
+				_, err := fs.Stat(fsys, ".")
+				return err
+			}
+		}
+	}
+}
+
+// fsName converts a path below the served directory to a name in app.fsys
+func (app *App) fsName(filePath string) string {
+	rel := strings.TrimPrefix(filePath, strings.TrimSuffix(app.params.Directory, "/"))
+	rel = strings.TrimPrefix(rel, "/")
+	if rel == "" {
+		return "."
+	}
+	return rel
+}
+
+func (app *App) open(filePath string) (fs.File, error) {
+	if app.fsys != nil {
+		return app.fsys.Open(app.fsName(filePath))
+	}
+	dirPath, fileName := filepath.Split(filePath)
+	return http.Dir(dirPath).Open(fileName)
+}
+
+func (app *App) stat(filePath string) (fs.FileInfo, error) {
+	if app.fsys != nil {
+		return fs.Stat(app.fsys, app.fsName(filePath))
+	}
+	return os.Stat(filePath)
+}
+
+func (app *App) readDir(dirPath string) ([]fs.DirEntry, error) {
+	if app.fsys != nil {
+		return fs.ReadDir(app.fsys, app.fsName(dirPath))
+	}
+	return os.ReadDir(dirPath)
+}
+
+func (app *App) fileType(filePath string) util.FileType {
+	if app.fsys == nil {
+		return util.GetFileType(filePath)
+	}
+	stat, err := app.stat(filePath)
+	if err != nil {
+		return util.FileTypeNotExists
+	} else if stat.IsDir() {
+		return util.FileTypeDirectory
+	}
+	return util.FileTypeFile
+}
+
+// tarEntry is a file or directory of a tarball, directories without their own
+// header are synthesized from the paths of their children
+type tarEntry struct {
+	info     fs.FileInfo
+	data     []byte
+	children []fs.DirEntry
+}
+
+type tarFS map[string]*tarEntry
+
+func newTarFS(r io.Reader) (tarFS, error) {
+	fsys := tarFS{".": {info: tarDirInfo{name: "."}}}
+	reader := tar.NewReader(r)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		name := path.Clean(strings.TrimPrefix(header.Name, "/"))
+		if name == "." || !fs.ValidPath(name) {
+			continue
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if entry, ok := fsys[name]; ok {
+				entry.info = header.FileInfo()
+			} else {
+				fsys.add(name, &tarEntry{info: header.FileInfo()})
+			}
+		case tar.TypeReg:
+			data, err := io.ReadAll(reader)
+			if err != nil {
+				return nil, err
+			}
+			fsys.add(name, &tarEntry{info: header.FileInfo(), data: data})
+		}
+	}
+	return fsys, nil
+}
+
+func (fsys tarFS) add(name string, entry *tarEntry) {
+	fsys[name] = entry
+	dir := path.Dir(name)
+	parent, ok := fsys[dir]
+	if !ok {
+		parent = &tarEntry{info: tarDirInfo{name: path.Base(dir)}}
+		fsys.add(dir, parent)
+	}
+	parent.children = append(parent.children, fs.FileInfoToDirEntry(entry.info))
+}
+
+func (fsys tarFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	entry, ok := fsys[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return &tarFile{Reader: bytes.NewReader(entry.data), entry: entry}, nil
+}
+
+type tarFile struct {
+	*bytes.Reader
+	entry *tarEntry
+	// children already returned by ReadDir
+	offset int
+}
+
+func (f *tarFile) Stat() (fs.FileInfo, error) { return f.entry.info, nil }
+
+func (f *tarFile) Close() error { return nil }
+
+func (f *tarFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !f.entry.info.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: f.entry.info.Name(), Err: fs.ErrInvalid}
+	}
+
+	children := f.entry.children[f.offset:]
+	if n > 0 && len(children) == 0 {
+		return nil, io.EOF
+	}
+	if n > 0 && n < len(children) {
+		children = children[:n]
+	}
+	f.offset += len(children)
+	return children, nil
+}
+
+// tarDirInfo describes a directory without a tar header
+type tarDirInfo struct {
+	name string
+}
+
+func (i tarDirInfo) Name() string       { return i.name }
+func (i tarDirInfo) Size() int64        { return 0 }
+func (i tarDirInfo) Mode() fs.FileMode  { return fs.ModeDir | 0555 }
+func (i tarDirInfo) ModTime() time.Time { return time.Time{} }
+func (i tarDirInfo) IsDir() bool        { return true }
+func (i tarDirInfo) Sys() any           { return nil }
@@ -0,0 +1,127 @@
+package app_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"go-http-server/app"
+	"go-http-server/param"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var archiveFiles = []struct {
+	name    string
+	content string
+}{
+	{"index.html", "shell"},
+	{"assets/app.js", "console.log()"},
+	{"assets/app.js.gz", "gzipped app.js"},
+}
+
+func newTestZip(t *testing.T) fs.FS {
+	t.Helper()
+	var buf bytes.Buffer
+	writer := zip.NewWriter(&buf)
+	for _, file := range archiveFiles {
+		w, err := writer.Create(file.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = w.Write([]byte(file.content))
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	fsys, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fsys
+}
+
+func newTestTarball(t *testing.T) fs.FS {
+	t.Helper()
+	var buf bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buf)
+	writer := tar.NewWriter(gzipWriter)
+	for _, file := range archiveFiles {
+		header := &tar.Header{Name: "./" + file.name, Mode: 0644, Size: int64(len(file.content)), Typeflag: tar.TypeReg}
+		if err := writer.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		_, _ = writer.Write([]byte(file.content))
+	}
+	_ = writer.Close()
+	_ = gzipWriter.Close()
+
+	name := filepath.Join(t.TempDir(), "dist.tar.gz")
+	if err := os.WriteFile(name, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fsys, err := app.OpenArchive(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fsys
+}
+
+func TestServeFS(t *testing.T) {
+	archives := map[string]fs.FS{
+		"zip":    newTestZip(t),
+		"tar.gz": newTestTarball(t),
+	}
+
+	tests := []struct {
+		target         string
+		acceptEncoding string
+		code           int
+		body           string
+	}{
+		{"/assets/app.js", "", http.StatusOK, "console.log()"},
+		{"/assets/app.js", "gzip", http.StatusOK, "gzipped app.js"},
+		{"/", "", http.StatusOK, "shell"},
+		{"/some/route", "", http.StatusOK, "shell"},
+		{"/assets/missing.js", "", http.StatusOK, "shell"},
+		{"/../index.html", "", http.StatusNotFound, ""},
+	}
+
+	for kind, fsys := range archives {
+		params := param.Params{
+			Address:            "0.0.0.0",
+			Port:               8080,
+			Gzip:               true,
+			Threshold:          0,
+			Directory:          "/srv/www",
+			CacheControlMaxAge: 604800,
+			SpaMode:            true,
+			CacheEnabled:       true,
+			CacheBuffer:        50 * 1024,
+		}
+		app1 := app.NewApp(&params)
+		app1.ServeFS(fsys)
+
+		for _, tt := range tests {
+			req := httptest.NewRequest("GET", tt.target, nil)
+			if tt.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			recorder := httptest.NewRecorder()
+			app1.HandlerFuncNew(recorder, req)
+
+			if recorder.Code != tt.code || recorder.Body.String() != tt.body {
+				t.Errorf("%s %s: expected %d %q, got %d %q", kind, tt.target, tt.code, tt.body, recorder.Code, recorder.Body.String())
+			}
+		}
+	}
+
+	if _, err := app.OpenArchive("dist.rar"); err == nil {
+		t.Errorf("Expected unsupported archive to return an error")
+	}
+}
@@ -0,0 +1,47 @@
+package app
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"go-http-server/param"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// matchAuthRule returns the rule with the longest path prefix covering urlPath
+func (app *App) matchAuthRule(urlPath string) *param.AuthRule {
+	urlPath = path.Clean("/" + urlPath)
+
+	var match *param.AuthRule
+	for i, rule := range app.params.AuthRules {
+		prefix := strings.TrimSuffix(rule.Prefix, "/")
+		if urlPath != prefix && !strings.HasPrefix(urlPath, prefix+"/") {
+			continue
+		}
+		if match == nil || len(rule.Prefix) > len(match.Prefix) {
+			match = &app.params.AuthRules[i]
+		}
+	}
+	return match
+}
+
+// Authorize checks basic auth credentials for protected paths, answering
+// 401 with the rule realm and returning false when they are missing or wrong
+func (app *App) Authorize(w http.ResponseWriter, r *http.Request) bool {
+	rule := app.matchAuthRule(r.URL.Path)
+	if rule == nil {
+		return true
+	}
+
+	if user, password, ok := r.BasicAuth(); ok {
+		expected, known := rule.Credentials[user]
+		if known && subtle.ConstantTimeCompare([]byte(password), []byte(expected)) == 1 {
+			return true
+		}
+	}
+
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Basic realm=%q, charset="UTF-8"`, rule.Realm))
+	w.WriteHeader(http.StatusUnauthorized)
+	return false
+}
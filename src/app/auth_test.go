@@ -0,0 +1,95 @@
+package app_test
+
+import (
+	"go-http-server/app"
+	"go-http-server/param"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAuthorize(t *testing.T) {
+	params := param.Params{
+		Address:            "0.0.0.0",
+		Port:               8080,
+		Threshold:          1024,
+		Directory:          newTestDir(t, map[string]string{"index.html": "public", "admin/index.html": "admin"}),
+		CacheControlMaxAge: 604800,
+		SpaMode:            false,
+		CacheEnabled:       true,
+		CacheBuffer:        50 * 1024,
+		AuthRules: []param.AuthRule{
+			{Prefix: "/admin", Realm: "Admin area", Credentials: map[string]string{"alice": "secret"}},
+		},
+	}
+	app1 := app.NewApp(&params)
+
+	tests := []struct {
+		name     string
+		path     string
+		user     string
+		password string
+		code     int
+		body     string
+	}{
+		{"public path", "/", "", "", http.StatusOK, "public"},
+		{"prefix lookalike stays public", "/administrator", "", "", http.StatusNotFound, ""},
+		{"missing credentials", "/admin/", "", "", http.StatusUnauthorized, ""},
+		{"wrong password", "/admin/", "alice", "nope", http.StatusUnauthorized, ""},
+		{"traversal into protected path", "/public/../admin/", "", "", http.StatusUnauthorized, ""},
+		{"valid credentials", "/admin/", "alice", "secret", http.StatusOK, "admin"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.path, nil)
+			if tt.user != "" {
+				req.SetBasicAuth(tt.user, tt.password)
+			}
+			recorder := httptest.NewRecorder()
+			app1.HandlerFuncNew(recorder, req)
+			if recorder.Code != tt.code {
+				t.Errorf("Expected status %d, got %d", tt.code, recorder.Code)
+			}
+			if recorder.Body.String() != tt.body {
+				t.Errorf("Expected body %q, got %q", tt.body, recorder.Body)
+			}
+			if tt.code == http.StatusUnauthorized && recorder.Header().Get("WWW-Authenticate") != `Basic realm="Admin area", charset="UTF-8"` {
+				t.Errorf("Expected WWW-Authenticate with the rule realm, got %s", recorder.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+func TestAuthorizeMetrics(t *testing.T) {
+	params := param.Params{
+		Address:            "0.0.0.0",
+		Port:               8080,
+		Threshold:          1024,
+		Directory:          newTestDir(t, map[string]string{"index.html": "public"}),
+		CacheControlMaxAge: 604800,
+		SpaMode:            false,
+		CacheEnabled:       true,
+		CacheBuffer:        50 * 1024,
+		MetricsPath:        "/metrics",
+		AuthRules: []param.AuthRule{
+			{Prefix: "/", Realm: "Site", Credentials: map[string]string{"alice": "secret"}},
+		},
+	}
+	app1 := app.NewApp(&params)
+
+	recorder := httptest.NewRecorder()
+	app1.HandlerFuncNew(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for metrics without credentials, got %d", recorder.Code)
+	}
+
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	req.SetBasicAuth("alice", "secret")
+	recorder = httptest.NewRecorder()
+	app1.HandlerFuncNew(recorder, req)
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), "http_requests_total") {
+		t.Errorf("Expected metrics with credentials, got %d %q", recorder.Code, recorder.Body.String())
+	}
+}
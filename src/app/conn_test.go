@@ -0,0 +1,59 @@
+//go:build linux
+
+package app_test
+
+import (
+	"context"
+	"go-http-server/app"
+	"go-http-server/param"
+	"net"
+	"syscall"
+	"testing"
+)
+
+func socketBuffer(t *testing.T, conn *net.TCPConn, opt int) int {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var size int
+	var sockErr error
+	err = rawConn.Control(func(fd uintptr) {
+		size, sockErr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, opt)
+	})
+	if err != nil || sockErr != nil {
+		t.Fatal(err, sockErr)
+	}
+	return size
+}
+
+func TestConnContextBufferSizes(t *testing.T) {
+	params := param.Params{ReadBufferSize: 64 * 1024, WriteBufferSize: 32 * 1024}
+	app1 := app.NewApp(&params)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	app1.ConnContext(context.Background(), conn)
+
+	// Linux doubles the requested size to account for bookkeeping overhead
+	if size := socketBuffer(t, conn.(*net.TCPConn), syscall.SO_RCVBUF); size != 2*params.ReadBufferSize {
+		t.Errorf("Expected read buffer of %d, got %d", 2*params.ReadBufferSize, size)
+	}
+	if size := socketBuffer(t, conn.(*net.TCPConn), syscall.SO_SNDBUF); size != 2*params.WriteBufferSize {
+		t.Errorf("Expected write buffer of %d, got %d", 2*params.WriteBufferSize, size)
+	}
+}
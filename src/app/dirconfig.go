@@ -0,0 +1,92 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// DirectoryConfigName is the per-directory overrides file, never served itself
+const DirectoryConfigName = ".spa-config"
+
+// DirectoryConfig overrides response headers for the files of a subtree,
+// nested configs take precedence over the ones of parent directories
+type DirectoryConfig struct {
+	// CacheControl replaces the Cache-Control value computed from the global options
+	CacheControl string `json:"cacheControl,omitempty"`
+	// Headers are set on every response of the subtree
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// LoadDirectoryConfigs reads every DirectoryConfigName file below the served directory
+func (app *App) LoadDirectoryConfigs() error {
+	if !app.params.DirectoryConfig {
+		return nil
+	}
+
+	fsys := app.fsys
+	if fsys == nil {
+		fsys = os.DirFS(app.params.Directory)
+	}
+
+	configs := map[string]DirectoryConfig{}
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || d.Name() != DirectoryConfigName {
+			return nil
+		}
+
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		filePath := filepath.Join(app.params.Directory, name)
+		var config DirectoryConfig
+		if err := json.Unmarshal(data, &config); err != nil {
+			return fmt.Errorf("invalid %s: %w", filePath, err)
+		}
+		configs[filepath.Dir(filePath)] = config
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	app.directoryConfigs = configs
+	return nil
+}
+
+// applyDirectoryConfigs sets the headers configured for the directories
+// containing filePath, from the served directory down. It reports whether
+// any of them applied
+func (app *App) applyDirectoryConfigs(w http.ResponseWriter, filePath string) bool {
+	if len(app.directoryConfigs) == 0 {
+		return false
+	}
+
+	var chain []DirectoryConfig
+	root := filepath.Clean(app.params.Directory)
+	for dir := filepath.Dir(filePath); ; dir = filepath.Dir(dir) {
+		if config, ok := app.directoryConfigs[dir]; ok {
+			chain = append(chain, config)
+		}
+		if dir == root || dir == filepath.Dir(dir) {
+			break
+		}
+	}
+
+	for i := len(chain) - 1; i >= 0; i-- {
+		if chain[i].CacheControl != "" {
+			w.Header().Set("Cache-Control", chain[i].CacheControl)
+		}
+		for name, value := range chain[i].Headers {
+			w.Header().Set(name, value)
+		}
+	}
+	return len(chain) > 0
+}
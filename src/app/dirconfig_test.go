@@ -0,0 +1,77 @@
+package app_test
+
+import (
+	"go-http-server/app"
+	"go-http-server/param"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDirectoryConfigs(t *testing.T) {
+	params := param.Params{
+		Address:   "0.0.0.0",
+		Port:      8080,
+		Threshold: 1024,
+		Directory: newTestDir(t, map[string]string{
+			"index.html":                "shell",
+			"app.js":                    "console.log()",
+			"docs/guide.js":             "console.log()",
+			"docs/.spa-config":          `{"cacheControl": "max-age=60", "headers": {"X-Team": "docs", "X-Robots-Tag": "noindex"}}`,
+			"docs/internal/notes.js":    "console.log()",
+			"docs/internal/.spa-config": `{"headers": {"X-Team": "internal"}}`,
+			"widgets/big.png":           "png",
+			"widgets/.spa-config":       `{"cacheControl": "public, max-age=3600"}`,
+		}),
+		CacheControlMaxAge: 604800,
+		SpaMode:            false,
+		CacheEnabled:       true,
+		CacheBuffer:        50 * 1024,
+		NoCompress:         []string{".png"},
+		DirectoryConfig:    true,
+	}
+	app1 := app.NewApp(&params)
+	if err := app1.LoadDirectoryConfigs(); err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+
+	tests := []struct {
+		path         string
+		code         int
+		cacheControl string
+		team         string
+		robots       string
+	}{
+		{"/app.js", http.StatusOK, "max-age=604800", "", ""},
+		{"/docs/guide.js", http.StatusOK, "max-age=60", "docs", "noindex"},
+		{"/docs/internal/notes.js", http.StatusOK, "max-age=60", "internal", "noindex"},
+		{"/widgets/big.png", http.StatusOK, "public, max-age=3600", "", ""},
+		{"/docs/.spa-config", http.StatusNotFound, "", "", ""},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", tt.path, nil)
+		recorder := httptest.NewRecorder()
+		app1.HandlerFuncNew(recorder, req)
+		if recorder.Code != tt.code {
+			t.Errorf("%s: expected status %d, got %d", tt.path, tt.code, recorder.Code)
+		}
+		if recorder.Header().Get("Cache-Control") != tt.cacheControl {
+			t.Errorf("%s: expected Cache-Control %q, got %q", tt.path, tt.cacheControl, recorder.Header().Get("Cache-Control"))
+		}
+		if recorder.Header().Get("X-Team") != tt.team || recorder.Header().Get("X-Robots-Tag") != tt.robots {
+			t.Errorf("%s: expected X-Team %q and X-Robots-Tag %q, got %v", tt.path, tt.team, tt.robots, recorder.Header())
+		}
+	}
+}
+
+func TestDirectoryConfigsInvalid(t *testing.T) {
+	params := param.Params{
+		Directory:       newTestDir(t, map[string]string{"docs/.spa-config": "{"}),
+		DirectoryConfig: true,
+	}
+	app1 := app.NewApp(&params)
+	if err := app1.LoadDirectoryConfigs(); err == nil {
+		t.Errorf("Expected an invalid config to return an error")
+	}
+}
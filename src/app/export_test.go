@@ -0,0 +1,13 @@
+package app
+
+// CachedFilePaths counts the GetFilePath entries, for tests only
+func (app *App) CachedFilePaths() int {
+	count := 0
+	if app.filePaths != nil {
+		app.filePaths.Range(func(_, _ any) bool {
+			count++
+			return true
+		})
+	}
+	return count
+}
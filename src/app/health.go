@@ -0,0 +1,100 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// HealthCheck returns an error when the checked dependency is unhealthy
+type HealthCheck func() error
+
+type healthCheckEntry struct {
+	name  string
+	check HealthCheck
+}
+
+type HealthCheckResult struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// only reported when health latency is enabled
+	LatencyMs *float64 `json:"latencyMs,omitempty"`
+}
+
+type HealthResponse struct {
+	Status    string     `json:"status"`
+	Timestamp *time.Time `json:"timestamp,omitempty"`
+	// bound address like "127.0.0.1:41234", only reported when health address is enabled
+	Address string              `json:"address,omitempty"`
+	Checks  []HealthCheckResult `json:"checks"`
+}
+
+const (
+	HealthStatusOK   = "ok"
+	HealthStatusFail = "fail"
+)
+
+// AddHealthCheck registers a check contributing to the health endpoint status,
+// checks must be registered before the server starts listening
+func (app *App) AddHealthCheck(name string, check HealthCheck) {
+	app.healthChecks = append(app.healthChecks, healthCheckEntry{name: name, check: check})
+}
+
+// DirectoryHealthCheck returns the default check, verifying the served directory is readable
+func DirectoryHealthCheck(directory string) HealthCheck {
+	return func() error {
+		stat, err := os.Stat(directory)
+		if err != nil {
+			return err
+		}
+		if !stat.IsDir() {
+			return fmt.Errorf("%s is not a directory", directory)
+		}
+		return nil
+	}
+}
+
+// writeServiceUnavailable writes every 503 so they all carry the configured Retry-After
+func (app *App) writeServiceUnavailable(w http.ResponseWriter) {
+	if app.params.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(app.params.RetryAfter))
+	}
+	w.WriteHeader(http.StatusServiceUnavailable)
+}
+
+func (app *App) HealthHandler(w http.ResponseWriter, r *http.Request) {
+	response := HealthResponse{Status: HealthStatusOK, Checks: make([]HealthCheckResult, 0, len(app.healthChecks))}
+	if app.params.HealthLatency {
+		now := time.Now().UTC()
+		response.Timestamp = &now
+	}
+	if app.params.HealthAddress && app.addr != nil {
+		response.Address = app.addr.String()
+	}
+	for _, entry := range app.healthChecks {
+		result := HealthCheckResult{Name: entry.name, Status: HealthStatusOK}
+		start := time.Now()
+		err := entry.check()
+		if app.params.HealthLatency {
+			latency := float64(time.Since(start).Microseconds()) / 1000
+			result.LatencyMs = &latency
+		}
+		if err != nil {
+			result.Status = HealthStatusFail
+			result.Error = err.Error()
+			response.Status = HealthStatusFail
+		}
+		response.Checks = append(response.Checks, result)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if response.Status != HealthStatusOK {
+		app.writeServiceUnavailable(w)
+	}
+	_ = json.NewEncoder(w).Encode(response)
+}
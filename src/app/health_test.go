@@ -0,0 +1,194 @@
+package app_test
+
+import (
+	"encoding/json"
+	"errors"
+	"go-http-server/app"
+	"go-http-server/param"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHealthHandler(t *testing.T) {
+	params := param.Params{
+		Address:            "0.0.0.0",
+		Port:               8080,
+		Threshold:          1024,
+		Directory:          "../../test/frontend/dist",
+		CacheControlMaxAge: 604800,
+		SpaMode:            true,
+		CacheEnabled:       true,
+		CacheBuffer:        50 * 1024,
+		HealthPath:         "/healthz",
+	}
+	app1 := app.NewApp(&params)
+
+	req1, _ := http.NewRequest("GET", "/healthz", nil)
+	recorder1 := httptest.NewRecorder()
+	app1.HandlerFuncNew(recorder1, req1)
+	if recorder1.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", recorder1.Code)
+	}
+	var health1 app.HealthResponse
+	if err := json.Unmarshal(recorder1.Body.Bytes(), &health1); err != nil {
+		t.Fatalf("Failed to parse health response: %v\n%s", err, recorder1.Body)
+	}
+	if health1.Status != app.HealthStatusOK || len(health1.Checks) != 1 || health1.Checks[0].Name != "directory" {
+		t.Errorf("Expected ok status with the default directory check, got %+v", health1)
+	}
+
+	app1.AddHealthCheck("upstream", func() error {
+		return errors.New("connection refused")
+	})
+
+	req2, _ := http.NewRequest("GET", "/healthz", nil)
+	recorder2 := httptest.NewRecorder()
+	app1.HandlerFuncNew(recorder2, req2)
+	if recorder2.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503, got %d", recorder2.Code)
+	}
+	if recorder2.Header().Get("Retry-After") != "" {
+		t.Errorf("Expected no Retry-After by default, got %s", recorder2.Header().Get("Retry-After"))
+	}
+	var health2 app.HealthResponse
+	if err := json.Unmarshal(recorder2.Body.Bytes(), &health2); err != nil {
+		t.Fatalf("Failed to parse health response: %v\n%s", err, recorder2.Body)
+	}
+	if health2.Status != app.HealthStatusFail {
+		t.Errorf("Expected fail status, got %s", health2.Status)
+	}
+	if len(health2.Checks) != 2 || health2.Checks[1].Name != "upstream" || health2.Checks[1].Status != app.HealthStatusFail || health2.Checks[1].Error != "connection refused" {
+		t.Errorf("Expected failing upstream check, got %+v", health2.Checks)
+	}
+	if health2.Checks[0].Status != app.HealthStatusOK {
+		t.Errorf("Expected directory check to pass, got %+v", health2.Checks[0])
+	}
+}
+
+func TestHealthHandlerDisabled(t *testing.T) {
+	params := param.Params{
+		Address:            "0.0.0.0",
+		Port:               8080,
+		Threshold:          1024,
+		Directory:          "../../test/frontend/dist",
+		CacheControlMaxAge: 604800,
+		SpaMode:            false,
+		CacheEnabled:       true,
+		CacheBuffer:        50 * 1024,
+	}
+	app1 := app.NewApp(&params)
+
+	req, _ := http.NewRequest("GET", "/healthz", nil)
+	recorder := httptest.NewRecorder()
+	app1.HandlerFuncNew(recorder, req)
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 with health endpoint disabled, got %d", recorder.Code)
+	}
+}
+
+func TestHealthHandlerLatency(t *testing.T) {
+	params := param.Params{
+		Address:            "0.0.0.0",
+		Port:               8080,
+		Threshold:          1024,
+		Directory:          "../../test/frontend/dist",
+		CacheControlMaxAge: 604800,
+		SpaMode:            true,
+		CacheEnabled:       true,
+		CacheBuffer:        50 * 1024,
+		HealthPath:         "/healthz",
+		HealthLatency:      true,
+	}
+	app1 := app.NewApp(&params)
+	app1.AddHealthCheck("slow", func() error {
+		time.Sleep(20 * time.Millisecond)
+		return nil
+	})
+
+	req, _ := http.NewRequest("GET", "/healthz", nil)
+	recorder := httptest.NewRecorder()
+	app1.HandlerFuncNew(recorder, req)
+	if recorder.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", recorder.Code)
+	}
+	var health app.HealthResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &health); err != nil {
+		t.Fatalf("Failed to parse health response: %v\n%s", err, recorder.Body)
+	}
+	if health.Status != app.HealthStatusOK {
+		t.Errorf("Expected slow but passing check to be healthy, got %s", health.Status)
+	}
+	if health.Timestamp == nil || time.Since(*health.Timestamp) > time.Minute {
+		t.Errorf("Expected a current timestamp, got %v", health.Timestamp)
+	}
+	for _, check := range health.Checks {
+		if check.LatencyMs == nil {
+			t.Errorf("Expected %s check to report its latency", check.Name)
+		}
+	}
+	if len(health.Checks) != 2 || health.Checks[1].LatencyMs == nil || *health.Checks[1].LatencyMs < 20 {
+		t.Errorf("Expected slow check latency of at least 20ms, got %+v", health.Checks)
+	}
+}
+
+func TestHealthHandlerRetryAfter(t *testing.T) {
+	params := param.Params{
+		Directory:  "../../test/frontend/dist",
+		HealthPath: "/healthz",
+		RetryAfter: 30,
+	}
+	app1 := app.NewApp(&params)
+	app1.AddHealthCheck("upstream", func() error {
+		return errors.New("connection refused")
+	})
+
+	req, _ := http.NewRequest("GET", "/healthz", nil)
+	recorder := httptest.NewRecorder()
+	app1.HandlerFuncNew(recorder, req)
+	if recorder.Code != http.StatusServiceUnavailable || recorder.Header().Get("Retry-After") != "30" {
+		t.Errorf("Expected 503 with Retry-After 30, got %d %q", recorder.Code, recorder.Header().Get("Retry-After"))
+	}
+}
+
+func TestHealthAddress(t *testing.T) {
+	params := param.Params{
+		Address:            "127.0.0.1",
+		Port:               0,
+		Threshold:          1024,
+		Directory:          "../../test/frontend/dist",
+		CacheControlMaxAge: 604800,
+		SpaMode:            true,
+		CacheEnabled:       true,
+		CacheBuffer:        50 * 1024,
+		HealthPath:         "/healthz",
+		HealthAddress:      true,
+	}
+	app1 := app.NewApp(&params)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		_ = app1.Serve(listener)
+	}()
+
+	res, err := http.Get("http://" + listener.Addr().String() + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var health app.HealthResponse
+	if err := json.NewDecoder(res.Body).Decode(&health); err != nil {
+		t.Fatalf("Failed to parse health response: %v", err)
+	}
+
+	_, port, _ := net.SplitHostPort(health.Address)
+	if health.Address != listener.Addr().String() || port == "0" {
+		t.Errorf("Expected the resolved address %s, got %q", listener.Addr(), health.Address)
+	}
+}
@@ -0,0 +1,97 @@
+package app
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"go-http-server/util"
+	"io/fs"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type DirectoryEntry struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	IsDir   bool      `json:"isDir"`
+}
+
+// directoryListing is a rendered listing, valid while the directory mtime is unchanged
+type directoryListing struct {
+	modTime time.Time
+	body    []byte
+	gzipped []byte
+}
+
+// renderDirectoryListing leaves out hidden entries, the directory config file among them
+func (app *App) renderDirectoryListing(dirEntries []fs.DirEntry, modTime time.Time) *directoryListing {
+	entries := make([]DirectoryEntry, 0, len(dirEntries))
+	for _, dirEntry := range dirEntries {
+		if strings.HasPrefix(dirEntry.Name(), ".") || (app.params.DirectoryConfig && dirEntry.Name() == DirectoryConfigName) {
+			continue
+		}
+		info, err := dirEntry.Info()
+		if err != nil {
+			continue
+		}
+		entries = append(entries, DirectoryEntry{
+			Name:    info.Name(),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+			IsDir:   info.IsDir(),
+		})
+	}
+
+	var body bytes.Buffer
+	_ = json.NewEncoder(&body).Encode(entries)
+
+	var gzipped bytes.Buffer
+	writer := gzip.NewWriter(&gzipped)
+	_, _ = writer.Write(body.Bytes())
+	_ = writer.Close()
+
+	return &directoryListing{modTime: modTime, body: body.Bytes(), gzipped: gzipped.Bytes()}
+}
+
+// ServeDirectoryListing writes the entries of dirPath as a JSON array sorted by name.
+// With the cache enabled listings are rendered once per directory mtime, which
+// changes when entries are added, removed or renamed
+func (app *App) ServeDirectoryListing(w http.ResponseWriter, r *http.Request, dirPath string) {
+	stat, err := app.stat(dirPath)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var listing *directoryListing
+	if app.listings != nil {
+		if cached, ok := app.listings.Load(dirPath); ok && cached.(*directoryListing).modTime.Equal(stat.ModTime()) {
+			listing = cached.(*directoryListing)
+		}
+	}
+	if listing == nil {
+		dirEntries, err := app.readDir(dirPath)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		listing = app.renderDirectoryListing(dirEntries, stat.ModTime())
+		if app.listings != nil {
+			app.listings.Store(dirPath, listing)
+		}
+	}
+
+	body := listing.body
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	util.AddVary(w.Header(), "Accept-Encoding")
+	if util.ParseAcceptEncoding(r.Header.Get("Accept-Encoding")).Accepts("gzip") {
+		body = listing.gzipped
+		w.Header().Set("Content-Encoding", "gzip")
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	_, _ = w.Write(body)
+}
@@ -0,0 +1,174 @@
+package app_test
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"go-http-server/app"
+	"go-http-server/param"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestServeDirectoryListing(t *testing.T) {
+	params := param.Params{
+		Address:              "0.0.0.0",
+		Port:                 8080,
+		Threshold:            1024,
+		Directory:            newTestDir(t, map[string]string{"gallery/a.png": "aaaa", "gallery/b.png": "bb", "gallery/thumbs/c.png": "c"}),
+		CacheControlMaxAge:   604800,
+		SpaMode:              true,
+		CacheEnabled:         true,
+		CacheBuffer:          50 * 1024,
+		DirectoryListingJSON: true,
+	}
+	app1 := app.NewApp(&params)
+
+	req := httptest.NewRequest("GET", "/gallery/?format=json", nil)
+	recorder := httptest.NewRecorder()
+	app1.HandlerFuncNew(recorder, req)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", recorder.Code)
+	}
+	if recorder.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("Expected Content-Type = application/json, got %s", recorder.Header().Get("Content-Type"))
+	}
+
+	var entries []app.DirectoryEntry
+	if err := json.Unmarshal(recorder.Body.Bytes(), &entries); err != nil {
+		t.Fatalf("Failed to parse listing: %v\n%s", err, recorder.Body)
+	}
+	expected := []app.DirectoryEntry{
+		{Name: "a.png", Size: 4, IsDir: false},
+		{Name: "b.png", Size: 2, IsDir: false},
+		{Name: "thumbs", IsDir: true},
+	}
+	if len(entries) != len(expected) {
+		t.Fatalf("Expected %d entries, got %+v", len(expected), entries)
+	}
+	for i, entry := range entries {
+		if entry.Name != expected[i].Name || entry.IsDir != expected[i].IsDir || (!entry.IsDir && entry.Size != expected[i].Size) {
+			t.Errorf("Expected entry %+v, got %+v", expected[i], entry)
+		}
+		if entry.ModTime.IsZero() {
+			t.Errorf("Expected mtime for %s", entry.Name)
+		}
+	}
+}
+
+func TestServeDirectoryListingHidden(t *testing.T) {
+	params := param.Params{
+		Address:   "0.0.0.0",
+		Port:      8080,
+		Threshold: 1024,
+		Directory: newTestDir(t, map[string]string{
+			"gallery/a.png":                      "aaaa",
+			"gallery/.env":                       "SECRET=1",
+			"gallery/" + app.DirectoryConfigName: `{"headers":{"X-Gallery":"1"}}`,
+		}),
+		CacheControlMaxAge:   604800,
+		SpaMode:              true,
+		CacheEnabled:         true,
+		CacheBuffer:          50 * 1024,
+		DirectoryListingJSON: true,
+		DirectoryConfig:      true,
+	}
+	app1 := app.NewApp(&params)
+
+	recorder := httptest.NewRecorder()
+	app1.HandlerFuncNew(recorder, httptest.NewRequest("GET", "/gallery/?format=json", nil))
+	var entries []app.DirectoryEntry
+	if err := json.Unmarshal(recorder.Body.Bytes(), &entries); err != nil {
+		t.Fatalf("Failed to parse listing: %v\n%s", err, recorder.Body)
+	}
+	if len(entries) != 1 || entries[0].Name != "a.png" {
+		t.Errorf("Expected only a.png to be listed, got %+v", entries)
+	}
+}
+
+func TestServeDirectoryListingDisabled(t *testing.T) {
+	params := param.Params{
+		Address:            "0.0.0.0",
+		Port:               8080,
+		Threshold:          1024,
+		Directory:          newTestDir(t, map[string]string{"index.html": "<html></html>", "gallery/a.png": "aaaa"}),
+		CacheControlMaxAge: 604800,
+		SpaMode:            true,
+		CacheEnabled:       true,
+		CacheBuffer:        50 * 1024,
+	}
+	app1 := app.NewApp(&params)
+
+	req := httptest.NewRequest("GET", "/gallery/?format=json", nil)
+	recorder := httptest.NewRecorder()
+	app1.HandlerFuncNew(recorder, req)
+	if recorder.Body.String() != "<html></html>" {
+		t.Errorf("Expected the SPA index with listing disabled, got %s", recorder.Body)
+	}
+}
+
+func TestServeDirectoryListingCache(t *testing.T) {
+	params := param.Params{
+		Address:              "0.0.0.0",
+		Port:                 8080,
+		Threshold:            1024,
+		Directory:            newTestDir(t, map[string]string{"gallery/a.png": "aaaa"}),
+		CacheControlMaxAge:   604800,
+		SpaMode:              true,
+		CacheEnabled:         true,
+		CacheBuffer:          50 * 1024,
+		DirectoryListingJSON: true,
+	}
+	app1 := app.NewApp(&params)
+	gallery := filepath.Join(params.Directory, "gallery")
+
+	listing := func() []app.DirectoryEntry {
+		req := httptest.NewRequest("GET", "/gallery/?format=json", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		recorder := httptest.NewRecorder()
+		app1.HandlerFuncNew(recorder, req)
+		if recorder.Header().Get("Content-Encoding") != "gzip" {
+			t.Fatalf("Expected a gzip listing, got %q", recorder.Header().Get("Content-Encoding"))
+		}
+		reader, err := gzip.NewReader(recorder.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var entries []app.DirectoryEntry
+		if err := json.NewDecoder(reader).Decode(&entries); err != nil {
+			t.Fatalf("Failed to parse listing: %v", err)
+		}
+		return entries
+	}
+
+	if entries := listing(); len(entries) != 1 || entries[0].Size != 4 {
+		t.Fatalf("Expected a.png of 4 bytes, got %+v", entries)
+	}
+
+	// rewriting a file leaves the directory mtime alone, so the cached listing is reused
+	stat, _ := os.Stat(gallery)
+	if err := os.WriteFile(filepath.Join(gallery, "a.png"), []byte("aaaaaaaa"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(gallery, stat.ModTime(), stat.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	if entries := listing(); len(entries) != 1 || entries[0].Size != 4 {
+		t.Errorf("Expected the cached listing, got %+v", entries)
+	}
+
+	// adding an entry changes the mtime and invalidates it
+	if err := os.WriteFile(filepath.Join(gallery, "b.png"), []byte("bb"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(gallery, future, future); err != nil {
+		t.Fatal(err)
+	}
+	if entries := listing(); len(entries) != 2 || entries[0].Size != 8 {
+		t.Errorf("Expected a fresh listing with both files, got %+v", entries)
+	}
+}
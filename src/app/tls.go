@@ -0,0 +1,41 @@
+package app
+
+import (
+	"crypto/tls"
+	"fmt"
+	"go-http-server/param"
+)
+
+// TLSConfig loads TLSCert and the TLSSNICerts. Each handshake gets the first
+// certificate valid for its SNI name, TLSCert when none is. With TLSStrictSNI
+// handshakes without a matching SNI name fail instead, so probes for unknown
+// hosts learn nothing about the served ones
+func (app *App) TLSConfig() (*tls.Config, error) {
+	pairs := append([]param.TLSCertificate{{CertFile: app.params.TLSCert, KeyFile: app.params.TLSKey}}, app.params.TLSSNICerts...)
+	certs := make([]tls.Certificate, 0, len(pairs))
+	for _, pair := range pairs {
+		cert, err := tls.LoadX509KeyPair(pair.CertFile, pair.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+
+	return &tls.Config{
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if app.params.TLSStrictSNI && hello.ServerName == "" {
+				return nil, fmt.Errorf("tls handshake without sni")
+			}
+			for i := range certs {
+				// checks the SNI name against the DNS names of the certificate
+				if hello.SupportsCertificate(&certs[i]) == nil {
+					return &certs[i], nil
+				}
+			}
+			if app.params.TLSStrictSNI {
+				return nil, fmt.Errorf("no certificate for sni %q", hello.ServerName)
+			}
+			return &certs[0], nil
+		},
+	}, nil
+}
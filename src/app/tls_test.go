@@ -0,0 +1,105 @@
+package app_test
+
+import (
+	"crypto/tls"
+	"go-http-server/app"
+	"go-http-server/param"
+	"net"
+	"testing"
+)
+
+func TestTLSSNICerts(t *testing.T) {
+	certFile, keyFile := newTestCert(t, "a.example.com")
+	sniCertFile, sniKeyFile := newTestCert(t, "b.example.com")
+	params := param.Params{
+		Address:            "127.0.0.1",
+		Port:               0,
+		Threshold:          1024,
+		Directory:          newTestDir(t, map[string]string{"index.html": "shell"}),
+		CacheControlMaxAge: 604800,
+		SpaMode:            true,
+		CacheEnabled:       true,
+		CacheBuffer:        50 * 1024,
+		TLS:                true,
+		TLSCert:            certFile,
+		TLSKey:             keyFile,
+		TLSSNICerts:        []param.TLSCertificate{{CertFile: sniCertFile, KeyFile: sniKeyFile}},
+	}
+	app1 := app.NewApp(&params)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		_ = app1.Serve(listener)
+	}()
+
+	tests := []struct {
+		serverName string
+		expected   string
+	}{
+		{"a.example.com", "a.example.com"},
+		{"b.example.com", "b.example.com"},
+		{"c.example.com", "a.example.com"},
+		{"", "a.example.com"},
+	}
+	for _, tt := range tests {
+		conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{ServerName: tt.serverName, InsecureSkipVerify: true})
+		if err != nil {
+			t.Fatalf("SNI %q: %v", tt.serverName, err)
+		}
+		served := conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+		_ = conn.Close()
+		if served != tt.expected {
+			t.Errorf("SNI %q: expected the %s certificate, got %s", tt.serverName, tt.expected, served)
+		}
+	}
+}
+
+func TestTLSStrictSNI(t *testing.T) {
+	certFile, keyFile := newTestCert(t, "a.example.com")
+	params := param.Params{
+		Address:            "127.0.0.1",
+		Port:               0,
+		Threshold:          1024,
+		Directory:          newTestDir(t, map[string]string{"index.html": "shell"}),
+		CacheControlMaxAge: 604800,
+		SpaMode:            true,
+		CacheEnabled:       true,
+		CacheBuffer:        50 * 1024,
+		TLS:                true,
+		TLSCert:            certFile,
+		TLSKey:             keyFile,
+		TLSStrictSNI:       true,
+	}
+	app1 := app.NewApp(&params)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		_ = app1.Serve(listener)
+	}()
+
+	tests := []struct {
+		serverName string
+		accepted   bool
+	}{
+		{"a.example.com", true},
+		{"c.example.com", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{ServerName: tt.serverName, InsecureSkipVerify: true})
+		if err == nil {
+			_ = conn.Close()
+		}
+		if (err == nil) != tt.accepted {
+			t.Errorf("SNI %q: expected accepted %v, got %v", tt.serverName, tt.accepted, err)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package app
+
+import (
+	"go-http-server/util"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// TryFiles resolves urlPath through the TryFiles templates like nginx try_files,
+// "$uri" is replaced with the request path and the first existing file wins.
+// A "=<code>" entry ends the chain with that status
+func (app *App) TryFiles(urlPath string) (string, int) {
+	for _, template := range app.params.TryFiles {
+		if code, ok := strings.CutPrefix(template, "="); ok {
+			// validated by param.ContextToParams
+			status, _ := strconv.Atoi(code)
+			return "", status
+		}
+
+		target := strings.ReplaceAll(template, "$uri", urlPath)
+		if filePath, valid := app.GetFilePath(target); valid && app.fileType(filePath) == util.FileTypeFile {
+			return target, 0
+		}
+	}
+	return "", http.StatusNotFound
+}
@@ -0,0 +1,69 @@
+package app_test
+
+import (
+	"go-http-server/app"
+	"go-http-server/param"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTryFiles(t *testing.T) {
+	// outside.txt sits beside the served directory, traversal must not reach it
+	root := newTestDir(t, map[string]string{
+		"site/index.html":      "shell",
+		"site/app.js":          "console.log()",
+		"site/docs/index.html": "docs",
+		"site/fallback.html":   "fallback",
+		"outside.txt":          "secret",
+	})
+	params := param.Params{
+		Address:            "0.0.0.0",
+		Port:               8080,
+		Threshold:          1024,
+		Directory:          filepath.Join(root, "site"),
+		CacheControlMaxAge: 604800,
+		SpaMode:            false,
+		CacheEnabled:       true,
+		CacheBuffer:        50 * 1024,
+		TryFiles:           []string{"$uri", "$uri/index.html", "$uri.html", "/fallback.html"},
+	}
+	app1 := app.NewApp(&params)
+
+	params2 := params
+	params2.TryFiles = []string{"$uri", "$uri/index.html", "=410"}
+	app2 := app.NewApp(&params2)
+
+	tests := []struct {
+		name   string
+		app    app.App
+		target string
+		code   int
+		body   string
+	}{
+		{"exact file", app1, "/app.js", http.StatusOK, "console.log()"},
+		{"second entry", app1, "/docs", http.StatusOK, "docs"},
+		{"third entry", app1, "/fallback", http.StatusOK, "fallback"},
+		{"last entry", app1, "/missing/route", http.StatusOK, "fallback"},
+		{"status entry", app2, "/missing/route", http.StatusGone, ""},
+		{"traversal skipped", app2, "/../outside.txt", http.StatusGone, ""},
+		{"traversal falls through", app1, "/../outside.txt", http.StatusOK, "fallback"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.URL.Path = tt.target
+			recorder := httptest.NewRecorder()
+			tt.app.HandlerFuncNew(recorder, req)
+			if recorder.Code != tt.code || recorder.Body.String() != tt.body {
+				t.Errorf("Expected %d %q, got %d %q", tt.code, tt.body, recorder.Code, recorder.Body)
+			}
+			if strings.Contains(recorder.Body.String(), "secret") {
+				t.Errorf("Expected the file outside the directory not to be served, got %q", recorder.Body)
+			}
+		})
+	}
+}
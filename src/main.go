@@ -19,6 +19,16 @@ func main() {
 			}
 
 			newApp := app.NewApp(params)
+			if params.Archive != "" {
+				fsys, err := app.OpenArchive(params.Archive)
+				if err != nil {
+					return err
+				}
+				newApp.ServeFS(fsys)
+			}
+			if err := newApp.LoadDirectoryConfigs(); err != nil {
+				return err
+			}
 			go newApp.CompressFiles()
 			newApp.Listen()
 
@@ -1,10 +1,162 @@
 package param
 
 import (
+	"fmt"
 	"github.com/urfave/cli/v2"
+	"go-http-server/util"
+	"log/slog"
+	"path"
 	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// Commit is the build commit, set at build time via
+// -ldflags "-X go-http-server/param.Commit=<sha>"
+var Commit string
+
+// AuthRule protects paths under Prefix with basic auth
+type AuthRule struct {
+	Prefix string
+	Realm  string
+	// user name to password
+	Credentials map[string]string
+}
+
+// TLSCertificate is a certificate served besides TLSCert to the SNI names it is valid for
+type TLSCertificate struct {
+	CertFile string
+	KeyFile  string
+}
+
+// ETagStrategy is how ETags are derived when they are enabled
+type ETagStrategy string
+
+const (
+	// ETagStrategyContent hashes the served bytes into a strong tag
+	ETagStrategyContent ETagStrategy = "content"
+	// ETagStrategyModTime derives a weak tag from size and modification time
+	ETagStrategyModTime ETagStrategy = "mtime"
+)
+
+// ParseETagStrategy validates an ETag strategy, empty means content
+func ParseETagStrategy(s string) (ETagStrategy, error) {
+	switch strategy := ETagStrategy(s); strategy {
+	case "", ETagStrategyContent, ETagStrategyModTime:
+		return strategy, nil
+	default:
+		return "", fmt.Errorf("unknown etag strategy %q, expected content or mtime", s)
+	}
+}
+
+// CacheControlRule sets Cache-Control for the files matching any of Patterns
+type CacheControlRule struct {
+	Patterns []string
+	Value    string
+}
+
+// Matches reports whether a pattern matches the file name, or the URL path
+// for patterns containing a "/"
+func (rule CacheControlRule) Matches(urlPath string, name string) bool {
+	for _, pattern := range rule.Patterns {
+		subject := name
+		if strings.Contains(pattern, "/") {
+			subject = urlPath
+		}
+		if matched, _ := path.Match(pattern, subject); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseCacheControlRules parses "<pattern>[,<pattern>...] => <value>" rules
+// separated by ";", values keep their commas
+func ParseCacheControlRules(s string) ([]CacheControlRule, error) {
+	var rules []CacheControlRule
+	for _, entry := range strings.Split(s, ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		patterns, value, ok := strings.Cut(entry, "=>")
+		value = strings.TrimSpace(value)
+		if !ok || value == "" {
+			return nil, fmt.Errorf("invalid cache control rule %q, expected <pattern>[,<pattern>...] => <value>", entry)
+		}
+
+		rule := CacheControlRule{Value: value}
+		for _, pattern := range strings.Split(patterns, ",") {
+			pattern = strings.TrimSpace(pattern)
+			if _, err := path.Match(pattern, ""); pattern == "" || err != nil {
+				return nil, fmt.Errorf("invalid pattern %q in cache control rule %q", pattern, entry)
+			}
+			rule.Patterns = append(rule.Patterns, pattern)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// ParseUserAgentPatterns parses User-Agent regular expressions separated by ";",
+// commas are left to the expressions, e.g. in "bot{2,}"
+func ParseUserAgentPatterns(s string) ([]*regexp.Regexp, error) {
+	var patterns []*regexp.Regexp
+	for _, entry := range strings.Split(s, ";") {
+		if entry == "" {
+			continue
+		}
+		pattern, err := regexp.Compile(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", entry, err)
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+// ParseTLSCertificate parses a "<cert>|<key>" pair of PEM files
+func ParseTLSCertificate(s string) (TLSCertificate, error) {
+	certFile, keyFile, ok := strings.Cut(s, "|")
+	if !ok || certFile == "" || keyFile == "" {
+		return TLSCertificate{}, fmt.Errorf("invalid tls sni certificate %q, expected <cert>|<key>", s)
+	}
+	return TLSCertificate{CertFile: certFile, KeyFile: keyFile}, nil
+}
+
+// ParseAuthRule parses a "<prefix>|<realm>|<user>:<password>[|<user>:<password>...]" rule
+func ParseAuthRule(s string) (AuthRule, error) {
+	parts := strings.Split(s, "|")
+	if len(parts) < 3 || !strings.HasPrefix(parts[0], "/") {
+		return AuthRule{}, fmt.Errorf("invalid basic auth rule %q, expected <prefix>|<realm>|<user>:<password>", s)
+	}
+
+	rule := AuthRule{Prefix: parts[0], Realm: parts[1], Credentials: map[string]string{}}
+	for _, credential := range parts[2:] {
+		user, password, ok := strings.Cut(credential, ":")
+		if !ok || user == "" {
+			return AuthRule{}, fmt.Errorf("invalid basic auth credential in rule for %s, expected <user>:<password>", rule.Prefix)
+		}
+		rule.Credentials[user] = password
+	}
+	return rule, nil
+}
+
+// validateTryFile accepts a "$uri" based or absolute path template, or a "=<code>" status
+func validateTryFile(template string) error {
+	if code, ok := strings.CutPrefix(template, "="); ok {
+		if status, err := strconv.Atoi(code); err != nil || status < 100 || status > 599 {
+			return fmt.Errorf("invalid try-files status %q", template)
+		}
+		return nil
+	}
+	if !strings.HasPrefix(template, "/") && !strings.HasPrefix(template, "$uri") {
+		return fmt.Errorf("invalid try-files entry %q, expected a path starting with / or $uri, or =<code>", template)
+	}
+	return nil
+}
+
 var Flags = []cli.Flag{
 	&cli.StringFlag{
 		EnvVars: []string{"ADDRESS"},
@@ -18,6 +170,36 @@ var Flags = []cli.Flag{
 		Aliases: []string{"p"},
 		Value:   8080,
 	},
+	&cli.BoolFlag{
+		EnvVars: []string{"TLS"},
+		Name:    "tls",
+		Value:   false,
+	},
+	&cli.StringFlag{
+		EnvVars: []string{"TLS_CERT"},
+		Name:    "tls-cert",
+		Value:   "",
+	},
+	&cli.StringFlag{
+		EnvVars: []string{"TLS_KEY"},
+		Name:    "tls-key",
+		Value:   "",
+	},
+	&cli.StringSliceFlag{
+		EnvVars: []string{"TLS_SNI_CERTS"},
+		Name:    "tls-sni-certs",
+		Value:   nil,
+	},
+	&cli.BoolFlag{
+		EnvVars: []string{"TLS_STRICT_SNI"},
+		Name:    "tls-strict-sni",
+		Value:   false,
+	},
+	&cli.IntFlag{
+		EnvVars: []string{"HTTPS_REDIRECT_PORT"},
+		Name:    "https-redirect-port",
+		Value:   0,
+	},
 	&cli.BoolFlag{
 		EnvVars: []string{"GZIP"},
 		Name:    "gzip",
@@ -28,17 +210,47 @@ var Flags = []cli.Flag{
 		Name:    "brotli",
 		Value:   false,
 	},
+	&cli.StringSliceFlag{
+		EnvVars: []string{"ENCODING_PREFERENCE"},
+		Name:    "encoding-preference",
+		Value:   cli.NewStringSlice("br", "gzip"),
+	},
 	&cli.Int64Flag{
 		EnvVars: []string{"THRESHOLD"},
 		Name:    "threshold",
 		Value:   1024,
 	},
+	&cli.IntFlag{
+		EnvVars: []string{"BROTLI_QUALITY"},
+		Name:    "brotli-quality",
+		Value:   6,
+	},
+	&cli.BoolFlag{
+		EnvVars: []string{"COMPRESS_RESPONSES"},
+		Name:    "compress-responses",
+		Value:   false,
+	},
+	&cli.IntFlag{
+		EnvVars: []string{"COMPRESS_MAX_CONCURRENT"},
+		Name:    "compress-max-concurrent",
+		Value:   0,
+	},
+	&cli.IntFlag{
+		EnvVars: []string{"COMPRESS_MAX_FILES"},
+		Name:    "compress-max-files",
+		Value:   0,
+	},
 	&cli.StringFlag{
 		EnvVars: []string{"DIRECTORY"},
 		Name:    "directory",
 		Aliases: []string{"d"},
 		Value:   ".",
 	},
+	&cli.StringFlag{
+		EnvVars: []string{"ARCHIVE"},
+		Name:    "archive",
+		Value:   "",
+	},
 	// TODO
 	//&cli.BoolFlag{
 	//	EnvVars: []string{"DIRECTORY_LISTING"},
@@ -55,11 +267,41 @@ var Flags = []cli.Flag{
 		Name:    "spa",
 		Value:   true,
 	},
+	&cli.StringFlag{
+		EnvVars: []string{"FALLBACK_FILE"},
+		Name:    "fallback-file",
+		Value:   "index.html",
+	},
+	&cli.IntFlag{
+		EnvVars: []string{"FALLBACK_STATUS"},
+		Name:    "fallback-status",
+		Value:   200,
+	},
+	&cli.BoolFlag{
+		EnvVars: []string{"SPA_HTML_ONLY"},
+		Name:    "spa-html-only",
+		Value:   false,
+	},
+	&cli.StringSliceFlag{
+		EnvVars: []string{"SPA_ROUTE_EXTENSIONS"},
+		Name:    "spa-route-extensions",
+		Value:   nil,
+	},
+	&cli.StringSliceFlag{
+		EnvVars: []string{"SPA_BYPASS_PREFIXES"},
+		Name:    "spa-bypass-prefixes",
+		Value:   nil,
+	},
 	&cli.StringSliceFlag{
 		EnvVars: []string{"IGNORE_CACHE_CONTROL_PATHS"},
 		Name:    "ignore-cache-control-paths",
 		Value:   nil,
 	},
+	&cli.StringFlag{
+		EnvVars: []string{"CACHE_CONTROL_RULES"},
+		Name:    "cache-control-rules",
+		Value:   "",
+	},
 	&cli.BoolFlag{
 		EnvVars: []string{"CACHE"},
 		Name:    "cache",
@@ -80,28 +322,393 @@ var Flags = []cli.Flag{
 		Name:    "log-pretty",
 		Value:   false,
 	},
+	&cli.StringFlag{
+		EnvVars: []string{"LOG_FORMAT"},
+		Name:    "log-format",
+		Value:   "",
+	},
+	&cli.StringFlag{
+		EnvVars: []string{"LOG_LEVEL"},
+		Name:    "log-level",
+		Value:   "info",
+	},
+	&cli.DurationFlag{
+		EnvVars: []string{"LOG_MIN_DURATION"},
+		Name:    "log-min-duration",
+		Value:   0,
+	},
+	&cli.Float64Flag{
+		EnvVars: []string{"LOG_SAMPLE_RATE"},
+		Name:    "log-sample-rate",
+		Value:   1,
+	},
+	&cli.StringFlag{
+		EnvVars: []string{"LOG_DURATION_UNIT"},
+		Name:    "log-duration-unit",
+		Value:   "ms",
+	},
+	&cli.BoolFlag{
+		EnvVars: []string{"LOG_REMOTE_PORT"},
+		Name:    "log-remote-port",
+		Value:   false,
+	},
+	&cli.StringSliceFlag{
+		EnvVars: []string{"TRUSTED_PROXIES"},
+		Name:    "trusted-proxies",
+		Value:   nil,
+	},
+	&cli.StringSliceFlag{
+		EnvVars: []string{"LOG_HEADER_ATTRS"},
+		Name:    "log-header-attrs",
+		Value:   nil,
+	},
+	&cli.StringSliceFlag{
+		EnvVars: []string{"LOG_REDACT_QUERY_PARAMS"},
+		Name:    "log-redact-query-params",
+		Value:   nil,
+	},
+	&cli.StringSliceFlag{
+		EnvVars: []string{"LOG_TRACE_FORMATS"},
+		Name:    "log-trace-formats",
+		Value:   nil,
+	},
+	&cli.StringFlag{
+		EnvVars: []string{"INSTANCE_ID"},
+		Name:    "instance-id",
+		Value:   "",
+	},
+	&cli.StringSliceFlag{
+		EnvVars: []string{"LOG_STATIC_FIELDS"},
+		Name:    "log-static-fields",
+		Value:   nil,
+	},
+	&cli.BoolFlag{
+		EnvVars: []string{"REQUEST_ID"},
+		Name:    "request-id",
+		Value:   false,
+	},
+	&cli.StringFlag{
+		EnvVars: []string{"REQUEST_ID_HEADER"},
+		Name:    "request-id-header",
+		Value:   util.DefaultRequestIDHeader,
+	},
+	&cli.BoolFlag{
+		EnvVars: []string{"LOG_COMPRESSION_SOURCE"},
+		Name:    "log-compression-source",
+		Value:   false,
+	},
+	&cli.BoolFlag{
+		EnvVars: []string{"LOG_COMPRESSION_DECISION"},
+		Name:    "log-compression-decision",
+		Value:   false,
+	},
+	&cli.BoolFlag{
+		EnvVars: []string{"LOG_REDIRECTS"},
+		Name:    "log-redirects",
+		Value:   false,
+	},
+	&cli.BoolFlag{
+		EnvVars: []string{"LOG_CACHE_RULE"},
+		Name:    "log-cache-rule",
+		Value:   false,
+	},
+	&cli.BoolFlag{
+		EnvVars: []string{"LOG_FILE_MTIME"},
+		Name:    "log-file-mtime",
+		Value:   false,
+	},
+	&cli.StringSliceFlag{
+		EnvVars: []string{"LOG_SKIP_PATHS"},
+		Name:    "log-skip-paths",
+		Value:   nil,
+	},
+	&cli.StringSliceFlag{
+		EnvVars: []string{"LOG_SKIP_PREFIXES"},
+		Name:    "log-skip-prefixes",
+		Value:   nil,
+	},
+	&cli.StringFlag{
+		EnvVars: []string{"LOG_MESSAGE_KEY"},
+		Name:    "log-message-key",
+		Value:   "",
+	},
+	&cli.StringFlag{
+		EnvVars: []string{"LOG_MESSAGE"},
+		Name:    "log-message",
+		Value:   "",
+	},
 	&cli.StringSliceFlag{
 		EnvVars: []string{"NO_COMPRESS"},
 		Name:    "no-compress",
 		Value:   nil,
 	},
+	&cli.IntFlag{
+		EnvVars: []string{"READ_BUFFER_SIZE"},
+		Name:    "read-buffer-size",
+		Value:   0,
+	},
+	&cli.IntFlag{
+		EnvVars: []string{"WRITE_BUFFER_SIZE"},
+		Name:    "write-buffer-size",
+		Value:   0,
+	},
+	&cli.BoolFlag{
+		EnvVars: []string{"DIRECTORY_CONFIG"},
+		Name:    "directory-config",
+		Value:   false,
+	},
+	&cli.BoolFlag{
+		EnvVars: []string{"ETAG"},
+		Name:    "etag",
+		Value:   false,
+	},
+	&cli.StringFlag{
+		EnvVars: []string{"ETAG_STRATEGY"},
+		Name:    "etag-strategy",
+		Value:   string(ETagStrategyContent),
+	},
+	&cli.BoolFlag{
+		EnvVars: []string{"IMMUTABLE"},
+		Name:    "immutable",
+		Value:   false,
+	},
+	&cli.StringFlag{
+		EnvVars: []string{"BROTLI_DENY_USER_AGENTS"},
+		Name:    "brotli-deny-user-agents",
+		Value:   "",
+	},
+	&cli.StringFlag{
+		EnvVars: []string{"DENY_USER_AGENTS"},
+		Name:    "deny-user-agents",
+		Value:   "",
+	},
+	&cli.StringFlag{
+		EnvVars: []string{"IMMUTABLE_PATTERN"},
+		Name:    "immutable-pattern",
+		Value:   `[.-][0-9a-fA-F]{6,}\.[0-9a-zA-Z]+$`,
+	},
+	&cli.BoolFlag{
+		EnvVars: []string{"DIRECTORY_LISTING_JSON"},
+		Name:    "directory-listing-json",
+		Value:   false,
+	},
+	&cli.StringSliceFlag{
+		EnvVars: []string{"ALLOWED_HOSTS"},
+		Name:    "allowed-hosts",
+		Value:   nil,
+	},
+	&cli.StringSliceFlag{
+		EnvVars: []string{"TRY_FILES"},
+		Name:    "try-files",
+		Value:   nil,
+	},
+	&cli.StringSliceFlag{
+		EnvVars: []string{"BASIC_AUTH"},
+		Name:    "basic-auth",
+		Value:   nil,
+	},
+	&cli.StringSliceFlag{
+		EnvVars: []string{"ALLOW_PATHS"},
+		Name:    "allow-paths",
+		Value:   nil,
+	},
+	&cli.BoolFlag{
+		EnvVars: []string{"NO_SNIFF"},
+		Name:    "no-sniff",
+		Value:   false,
+	},
+	&cli.StringSliceFlag{
+		EnvVars: []string{"NO_CONTENT_PATHS"},
+		Name:    "no-content-paths",
+		Value:   nil,
+	},
+	&cli.StringFlag{
+		EnvVars: []string{"HEALTH_PATH"},
+		Name:    "health-path",
+		Value:   "",
+	},
+	&cli.IntFlag{
+		EnvVars: []string{"MAX_QUERY_LENGTH"},
+		Name:    "max-query-length",
+		Value:   8192,
+	},
+	&cli.StringSliceFlag{
+		EnvVars: []string{"EARLY_HINTS"},
+		Name:    "early-hints",
+		Value:   nil,
+	},
+	&cli.StringFlag{
+		EnvVars: []string{"METRICS_PATH"},
+		Name:    "metrics-path",
+		Value:   "",
+	},
+	&cli.IntFlag{
+		EnvVars: []string{"RETRY_AFTER"},
+		Name:    "retry-after",
+		Value:   0,
+	},
+	&cli.IntFlag{
+		EnvVars: []string{"ROOT_NO_INDEX_STATUS"},
+		Name:    "root-no-index-status",
+		Value:   404,
+	},
+	&cli.StringFlag{
+		EnvVars: []string{"ROOT_NO_INDEX_MESSAGE"},
+		Name:    "root-no-index-message",
+		Value:   "",
+	},
+	&cli.BoolFlag{
+		EnvVars: []string{"HEALTH_LATENCY"},
+		Name:    "health-latency",
+		Value:   false,
+	},
+	&cli.BoolFlag{
+		EnvVars: []string{"HEALTH_ADDRESS"},
+		Name:    "health-address",
+		Value:   false,
+	},
+	&cli.StringFlag{
+		EnvVars: []string{"COMMIT_HEADER"},
+		Name:    "commit-header",
+		Value:   "",
+	},
+	&cli.StringFlag{
+		EnvVars: []string{"CONTENT_SECURITY_POLICY"},
+		Name:    "content-security-policy",
+		Value:   "",
+	},
+	&cli.StringSliceFlag{
+		EnvVars: []string{"CORS_ALLOWED_ORIGINS"},
+		Name:    "cors-allowed-origins",
+		Value:   nil,
+	},
+	&cli.StringSliceFlag{
+		EnvVars: []string{"CORS_ALLOWED_METHODS"},
+		Name:    "cors-allowed-methods",
+		Value:   nil,
+	},
+	&cli.StringSliceFlag{
+		EnvVars: []string{"CORS_ALLOWED_HEADERS"},
+		Name:    "cors-allowed-headers",
+		Value:   nil,
+	},
+	&cli.BoolFlag{
+		EnvVars: []string{"CORS_ALLOW_CREDENTIALS"},
+		Name:    "cors-allow-credentials",
+		Value:   false,
+	},
+	&cli.IntFlag{
+		EnvVars: []string{"CORS_MAX_AGE"},
+		Name:    "cors-max-age",
+		Value:   0,
+	},
+	&cli.StringFlag{
+		EnvVars: []string{"HEADER_CONTENT_SECURITY_POLICY"},
+		Name:    "header-content-security-policy",
+		Value:   "",
+	},
+	&cli.StringFlag{
+		EnvVars: []string{"HEADER_STRICT_TRANSPORT_SECURITY"},
+		Name:    "header-strict-transport-security",
+		Value:   "",
+	},
+	&cli.StringFlag{
+		EnvVars: []string{"HEADER_X_FRAME_OPTIONS"},
+		Name:    "header-x-frame-options",
+		Value:   "",
+	},
+	&cli.StringFlag{
+		EnvVars: []string{"HEADER_X_CONTENT_TYPE_OPTIONS"},
+		Name:    "header-x-content-type-options",
+		Value:   "",
+	},
 }
 
 type Params struct {
 	Address                 string
 	Port                    int
+	TLS                     bool
+	TLSCert                 string
+	TLSKey                  string
+	TLSSNICerts             []TLSCertificate
+	TLSStrictSNI            bool
+	HTTPSRedirectPort       int
 	Gzip                    bool
 	Brotli                  bool
+	EncodingPreference      []string
 	Threshold               int64
+	CompressMaxFiles        int
+	CompressResponses       bool
+	CompressMaxConcurrent   int
+	BrotliQuality           int
 	Directory               string
+	Archive                 string
 	CacheControlMaxAge      int64
 	SpaMode                 bool
+	FallbackFile            string
+	FallbackStatus          int
+	SpaHtmlOnly             bool
+	SpaBypassPrefixes       []string
+	SpaRouteExtensions      []string
 	IgnoreCacheControlPaths []string
+	CacheControlRules       []CacheControlRule
 	CacheEnabled            bool
 	CacheBuffer             int
 	Logger                  bool
 	LogPretty               bool
+	LogFormat               util.LogFormat
+	LogLevel                slog.Level
+	LogDurationUnit         util.DurationUnit
+	LogMinDuration          time.Duration
+	LogSampleRate           float64
+	LogRemotePort           bool
+	TrustedProxies          []string
+	LogHeaderAttrs          []util.HeaderAttr
+	LogRedactQueryParams    []string
+	LogTraceFormats         []util.TraceFormat
+	InstanceID              string
+	LogStaticFields         map[string]string
+	RequestID               bool
+	RequestIDHeader         string
+	LogCompressionSource    bool
+	LogCompressionDecision  bool
+	LogRedirects            bool
+	LogCacheRule            bool
+	LogFileModTime          bool
+	LogSkipPaths            []string
+	LogSkipPrefixes         []string
+	LogMessageKey           string
+	LogMessage              string
 	NoCompress              []string
+	ReadBufferSize          int
+	WriteBufferSize         int
+	DirectoryConfig         bool
+	ETag                    bool
+	ETagStrategy            ETagStrategy
+	ImmutablePattern        *regexp.Regexp
+	DenyUserAgents          []*regexp.Regexp
+	BrotliDenyUserAgents    []*regexp.Regexp
+	DirectoryListingJSON    bool
+	AllowedHosts            []string
+	TryFiles                []string
+	AuthRules               []AuthRule
+	AllowPaths              []string
+	NoSniff                 bool
+	NoContentPaths          []string
+	HealthPath              string
+	MetricsPath             string
+	EarlyHints              []string
+	MaxQueryLength          int
+	HealthLatency           bool
+	HealthAddress           bool
+	RetryAfter              int
+	RootNoIndexStatus       int
+	RootNoIndexMessage      string
+	CommitHeader            string
+	ContentSecurityPolicy   string
+	SecurityHeaders         util.SecurityHeaders
+	CORS                    util.CORSOptions
+	Commit                  string
 	//DirectoryListing        bool
 }
 
@@ -111,21 +718,279 @@ func ContextToParams(c *cli.Context) (*Params, error) {
 		return nil, err
 	}
 
+	logFormat, err := util.ParseLogFormat(c.String("log-format"))
+	if err != nil {
+		return nil, err
+	}
+
+	var immutablePattern *regexp.Regexp
+	if c.Bool("immutable") {
+		immutablePattern, err = regexp.Compile(c.String("immutable-pattern"))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	denyUserAgents, err := ParseUserAgentPatterns(c.String("deny-user-agents"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid deny-user-agents: %w", err)
+	}
+
+	brotliDenyUserAgents, err := ParseUserAgentPatterns(c.String("brotli-deny-user-agents"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid brotli-deny-user-agents: %w", err)
+	}
+
+	for _, template := range c.StringSlice("try-files") {
+		if err := validateTryFile(template); err != nil {
+			return nil, err
+		}
+	}
+
+	var authRules []AuthRule
+	for _, value := range c.StringSlice("basic-auth") {
+		rule, err := ParseAuthRule(value)
+		if err != nil {
+			return nil, err
+		}
+		authRules = append(authRules, rule)
+	}
+
+	var logTraceFormats []util.TraceFormat
+	for _, value := range c.StringSlice("log-trace-formats") {
+		traceFormat, err := util.ParseTraceFormat(value)
+		if err != nil {
+			return nil, err
+		}
+		logTraceFormats = append(logTraceFormats, traceFormat)
+	}
+
+	instanceID := c.String("instance-id")
+	if instanceID == "" {
+		instanceID = util.NewInstanceID()
+	}
+
+	var logStaticFields map[string]string
+	for _, field := range c.StringSlice("log-static-fields") {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid static log field %q, expected <key>=<value>", field)
+		}
+		if logStaticFields == nil {
+			logStaticFields = map[string]string{}
+		}
+		logStaticFields[key] = value
+	}
+
+	logDurationUnit, err := util.ParseDurationUnit(c.String("log-duration-unit"))
+	if err != nil {
+		return nil, err
+	}
+
+	cacheControlRules, err := ParseCacheControlRules(c.String("cache-control-rules"))
+	if err != nil {
+		return nil, err
+	}
+
+	etagStrategy, err := ParseETagStrategy(c.String("etag-strategy"))
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Bool("tls") && (c.String("tls-cert") == "" || c.String("tls-key") == "") {
+		return nil, fmt.Errorf("tls requires both tls-cert and tls-key")
+	}
+
+	var tlsSNICerts []TLSCertificate
+	for _, value := range c.StringSlice("tls-sni-certs") {
+		cert, err := ParseTLSCertificate(value)
+		if err != nil {
+			return nil, err
+		}
+		tlsSNICerts = append(tlsSNICerts, cert)
+	}
+	if len(tlsSNICerts) > 0 && !c.Bool("tls") {
+		return nil, fmt.Errorf("tls-sni-certs requires tls")
+	}
+	if c.Bool("tls-strict-sni") && !c.Bool("tls") {
+		return nil, fmt.Errorf("tls-strict-sni requires tls")
+	}
+
+	httpsRedirectPort := c.Int("https-redirect-port")
+	if httpsRedirectPort < 0 || httpsRedirectPort > 65535 {
+		return nil, fmt.Errorf("invalid https-redirect-port %d", httpsRedirectPort)
+	}
+	if httpsRedirectPort != 0 && !c.Bool("tls") {
+		return nil, fmt.Errorf("https-redirect-port requires tls")
+	}
+
+	// both set the same header, one would silently win on HTML responses
+	if c.String("content-security-policy") != "" && c.String("header-content-security-policy") != "" {
+		return nil, fmt.Errorf("content-security-policy and header-content-security-policy cannot be used together")
+	}
+
+	cors := util.CORSOptions{
+		AllowedOrigins:   c.StringSlice("cors-allowed-origins"),
+		AllowedMethods:   c.StringSlice("cors-allowed-methods"),
+		AllowedHeaders:   c.StringSlice("cors-allowed-headers"),
+		AllowCredentials: c.Bool("cors-allow-credentials"),
+		MaxAge:           c.Int("cors-max-age"),
+	}
+	if err := cors.Validate(); err != nil {
+		return nil, err
+	}
+
+	fallbackFile := c.String("fallback-file")
+	if fallbackFile != "" && (path.IsAbs(fallbackFile) || path.Clean(fallbackFile) != fallbackFile || fallbackFile == ".." || strings.HasPrefix(fallbackFile, "../")) {
+		return nil, fmt.Errorf("invalid fallback-file %q, expected a clean path relative to the directory", fallbackFile)
+	}
+
+	fallbackStatus := c.Int("fallback-status")
+	if fallbackStatus != 0 && (fallbackStatus < 200 || fallbackStatus > 599) {
+		return nil, fmt.Errorf("invalid fallback-status %d, expected a status code between 200 and 599", fallbackStatus)
+	}
+
+	rootNoIndexStatus := c.Int("root-no-index-status")
+	if rootNoIndexStatus != 0 && (rootNoIndexStatus < 200 || rootNoIndexStatus > 599) {
+		return nil, fmt.Errorf("invalid root-no-index-status %d, expected a status code between 200 and 599", rootNoIndexStatus)
+	}
+
+	brotliQuality := c.Int("brotli-quality")
+	if brotliQuality < 0 || brotliQuality > 11 {
+		return nil, fmt.Errorf("invalid brotli quality %d, expected a value between 1 and 11", brotliQuality)
+	}
+
+	compressMaxConcurrent := c.Int("compress-max-concurrent")
+	if compressMaxConcurrent < 0 {
+		return nil, fmt.Errorf("invalid compress-max-concurrent %d, expected 0 or more", compressMaxConcurrent)
+	}
+
+	logSampleRate := c.Float64("log-sample-rate")
+	// unset stays 0, which logs every request like 1
+	if logSampleRate < 0 || logSampleRate > 1 || (logSampleRate == 0 && c.IsSet("log-sample-rate")) {
+		return nil, fmt.Errorf("invalid log sample rate %v, expected a value above 0 and at most 1", logSampleRate)
+	}
+
+	for _, proxy := range c.StringSlice("trusted-proxies") {
+		if _, err := util.ParseTrustedProxy(proxy); err != nil {
+			return nil, err
+		}
+	}
+
+	var logLevel slog.Level
+	if value := c.String("log-level"); value != "" {
+		if err := logLevel.UnmarshalText([]byte(value)); err != nil {
+			return nil, fmt.Errorf("invalid log level %q: %w", value, err)
+		}
+	}
+
+	var logHeaderAttrs []util.HeaderAttr
+	for _, rule := range c.StringSlice("log-header-attrs") {
+		headerAttr, err := util.ParseHeaderAttr(rule)
+		if err != nil {
+			return nil, err
+		}
+		logHeaderAttrs = append(logHeaderAttrs, headerAttr)
+	}
+
+	encodingPreference := c.StringSlice("encoding-preference")
+	for i, encoding := range encodingPreference {
+		encodingPreference[i] = strings.ToLower(strings.TrimSpace(encoding))
+		if encodingPreference[i] != "br" && encodingPreference[i] != "gzip" {
+			return nil, fmt.Errorf("unknown encoding %q, expected br or gzip", encoding)
+		}
+	}
+
 	return &Params{
 		Address:                 c.String("address"),
 		Port:                    c.Int("port"),
+		TLS:                     c.Bool("tls"),
+		TLSCert:                 c.String("tls-cert"),
+		TLSKey:                  c.String("tls-key"),
+		TLSSNICerts:             tlsSNICerts,
+		TLSStrictSNI:            c.Bool("tls-strict-sni"),
+		HTTPSRedirectPort:       httpsRedirectPort,
 		Gzip:                    c.Bool("gzip"),
 		Brotli:                  c.Bool("brotli"),
+		EncodingPreference:      encodingPreference,
 		Threshold:               c.Int64("threshold"),
+		CompressMaxFiles:        c.Int("compress-max-files"),
+		CompressResponses:       c.Bool("compress-responses"),
+		CompressMaxConcurrent:   compressMaxConcurrent,
+		BrotliQuality:           brotliQuality,
 		Directory:               directory,
+		Archive:                 c.String("archive"),
 		CacheControlMaxAge:      c.Int64("cache-max-age"),
 		SpaMode:                 c.Bool("spa"),
+		FallbackFile:            fallbackFile,
+		FallbackStatus:          fallbackStatus,
+		SpaHtmlOnly:             c.Bool("spa-html-only"),
+		SpaBypassPrefixes:       c.StringSlice("spa-bypass-prefixes"),
+		SpaRouteExtensions:      c.StringSlice("spa-route-extensions"),
 		IgnoreCacheControlPaths: c.StringSlice("ignore-cache-control-paths"),
+		CacheControlRules:       cacheControlRules,
 		CacheEnabled:            c.Bool("cache"),
 		CacheBuffer:             c.Int("cache-buffer"),
 		Logger:                  c.Bool("logger"),
 		LogPretty:               c.Bool("log-pretty"),
+		LogFormat:               logFormat,
+		LogLevel:                logLevel,
+		LogDurationUnit:         logDurationUnit,
+		LogMinDuration:          c.Duration("log-min-duration"),
+		LogSampleRate:           logSampleRate,
+		LogRemotePort:           c.Bool("log-remote-port"),
+		TrustedProxies:          c.StringSlice("trusted-proxies"),
+		LogHeaderAttrs:          logHeaderAttrs,
+		LogRedactQueryParams:    c.StringSlice("log-redact-query-params"),
+		LogTraceFormats:         logTraceFormats,
+		InstanceID:              instanceID,
+		LogStaticFields:         logStaticFields,
+		RequestID:               c.Bool("request-id"),
+		RequestIDHeader:         c.String("request-id-header"),
+		LogCompressionSource:    c.Bool("log-compression-source"),
+		LogCompressionDecision:  c.Bool("log-compression-decision"),
+		LogRedirects:            c.Bool("log-redirects"),
+		LogCacheRule:            c.Bool("log-cache-rule"),
+		LogFileModTime:          c.Bool("log-file-mtime"),
+		LogSkipPaths:            c.StringSlice("log-skip-paths"),
+		LogSkipPrefixes:         c.StringSlice("log-skip-prefixes"),
+		LogMessageKey:           c.String("log-message-key"),
+		LogMessage:              c.String("log-message"),
 		NoCompress:              c.StringSlice("no-compress"),
+		ReadBufferSize:          c.Int("read-buffer-size"),
+		WriteBufferSize:         c.Int("write-buffer-size"),
+		DirectoryConfig:         c.Bool("directory-config"),
+		ETag:                    c.Bool("etag"),
+		ETagStrategy:            etagStrategy,
+		ImmutablePattern:        immutablePattern,
+		DenyUserAgents:          denyUserAgents,
+		BrotliDenyUserAgents:    brotliDenyUserAgents,
+		DirectoryListingJSON:    c.Bool("directory-listing-json"),
+		AllowedHosts:            c.StringSlice("allowed-hosts"),
+		TryFiles:                c.StringSlice("try-files"),
+		AuthRules:               authRules,
+		AllowPaths:              c.StringSlice("allow-paths"),
+		NoSniff:                 c.Bool("no-sniff"),
+		NoContentPaths:          c.StringSlice("no-content-paths"),
+		HealthPath:              c.String("health-path"),
+		MetricsPath:             c.String("metrics-path"),
+		EarlyHints:              c.StringSlice("early-hints"),
+		MaxQueryLength:          c.Int("max-query-length"),
+		HealthLatency:           c.Bool("health-latency"),
+		HealthAddress:           c.Bool("health-address"),
+		RetryAfter:              c.Int("retry-after"),
+		RootNoIndexStatus:       rootNoIndexStatus,
+		RootNoIndexMessage:      c.String("root-no-index-message"),
+		CommitHeader:            c.String("commit-header"),
+		ContentSecurityPolicy:   c.String("content-security-policy"),
+		SecurityHeaders: util.SecurityHeaders{
+			ContentSecurityPolicy:   c.String("header-content-security-policy"),
+			StrictTransportSecurity: c.String("header-strict-transport-security"),
+			XFrameOptions:           c.String("header-x-frame-options"),
+			XContentTypeOptions:     c.String("header-x-content-type-options"),
+		},
+		CORS:   cors,
+		Commit: Commit,
 		//DirectoryListing:        c.Bool("directory-listing"),
 	}, nil
 }
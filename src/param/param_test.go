@@ -4,6 +4,7 @@ import (
 	"context"
 	"flag"
 	"go-http-server/param"
+	"log/slog"
 	"path/filepath"
 	"testing"
 
@@ -90,4 +91,267 @@ func TestContextToParams(t *testing.T) {
 	if params.CacheBuffer != e_cache_buffer {
 		t.Errorf("Got %d, expected %d", params.CacheBuffer, e_cache_buffer)
 	}
+
+	if params.ImmutablePattern != nil {
+		t.Errorf("Got %s, expected nil", params.ImmutablePattern)
+	}
+}
+
+func TestContextToParamsImmutablePattern(t *testing.T) {
+	f := flag.NewFlagSet("a", flag.ContinueOnError)
+	f.Bool("immutable", true, "")
+	f.String("immutable-pattern", `-[0-9a-f]{8}\.`, "")
+
+	ctx := cli.NewContext(nil, f, nil)
+	params, err := param.ContextToParams(ctx)
+	if err != nil {
+		t.Errorf("Error: %s", err)
+		return
+	}
+	if params.ImmutablePattern == nil || !params.ImmutablePattern.MatchString("chunk-0123abcd.js") {
+		t.Errorf("Expected pattern to match chunk-0123abcd.js, got %s", params.ImmutablePattern)
+	}
+
+	f.Set("immutable-pattern", "[")
+	if _, err := param.ContextToParams(ctx); err == nil {
+		t.Errorf("Expected invalid pattern to return an error")
+	}
+}
+
+func TestContextToParamsEncodingPreference(t *testing.T) {
+	set := flag.NewFlagSet("a", flag.ContinueOnError)
+	set.Var(cli.NewStringSlice("GZIP", " br"), "encoding-preference", "")
+
+	ctx := cli.NewContext(nil, set, nil)
+	params, err := param.ContextToParams(ctx)
+	if err != nil {
+		t.Errorf("Error: %s", err)
+		return
+	}
+	if len(params.EncodingPreference) != 2 || params.EncodingPreference[0] != "gzip" || params.EncodingPreference[1] != "br" {
+		t.Errorf("Got %v, expected [gzip br]", params.EncodingPreference)
+	}
+
+	set = flag.NewFlagSet("a", flag.ContinueOnError)
+	set.Var(cli.NewStringSlice("zstd"), "encoding-preference", "")
+	if _, err := param.ContextToParams(cli.NewContext(nil, set, nil)); err == nil {
+		t.Errorf("Expected unknown encoding to return an error")
+	}
+}
+
+func TestParseAuthRule(t *testing.T) {
+	rule, err := param.ParseAuthRule("/admin|Admin area|alice:s3cr:et|bob:hunter2")
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if rule.Prefix != "/admin" || rule.Realm != "Admin area" {
+		t.Errorf("Got %+v, expected prefix /admin and realm Admin area", rule)
+	}
+	if len(rule.Credentials) != 2 || rule.Credentials["alice"] != "s3cr:et" || rule.Credentials["bob"] != "hunter2" {
+		t.Errorf("Got %v, expected alice and bob credentials", rule.Credentials)
+	}
+
+	for _, invalid := range []string{"/admin|Admin", "admin|Admin|alice:secret", "/admin|Admin|alice"} {
+		if _, err := param.ParseAuthRule(invalid); err == nil {
+			t.Errorf("Expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestContextToParamsInstanceID(t *testing.T) {
+	f := flag.NewFlagSet("a", flag.ContinueOnError)
+	f.String("instance-id", "", "")
+
+	params, err := param.ContextToParams(cli.NewContext(nil, f, nil))
+	if err != nil {
+		t.Errorf("Error: %s", err)
+		return
+	}
+	if params.InstanceID == "" {
+		t.Errorf("Expected a generated instance ID")
+	}
+
+	f.Set("instance-id", "replica-1")
+	params, _ = param.ContextToParams(cli.NewContext(nil, f, nil))
+	if params.InstanceID != "replica-1" {
+		t.Errorf("Got %s, expected replica-1", params.InstanceID)
+	}
+}
+
+func TestContextToParamsLogLevel(t *testing.T) {
+	f := flag.NewFlagSet("a", flag.ContinueOnError)
+	f.String("log-level", "warn", "")
+
+	params, err := param.ContextToParams(cli.NewContext(nil, f, nil))
+	if err != nil {
+		t.Errorf("Error: %s", err)
+		return
+	}
+	if params.LogLevel != slog.LevelWarn {
+		t.Errorf("Got %s, expected WARN", params.LogLevel)
+	}
+
+	f.Set("log-level", "loud")
+	if _, err := param.ContextToParams(cli.NewContext(nil, f, nil)); err == nil {
+		t.Errorf("Expected unknown log level to return an error")
+	}
+}
+
+func TestContextToParamsLogSampleRate(t *testing.T) {
+	f := flag.NewFlagSet("a", flag.ContinueOnError)
+	f.Float64("log-sample-rate", 0.25, "")
+
+	params, err := param.ContextToParams(cli.NewContext(nil, f, nil))
+	if err != nil {
+		t.Errorf("Error: %s", err)
+		return
+	}
+	if params.LogSampleRate != 0.25 {
+		t.Errorf("Got %v, expected 0.25", params.LogSampleRate)
+	}
+
+	for _, invalid := range []string{"0", "-0.5", "1.5"} {
+		f.Set("log-sample-rate", invalid)
+		if _, err := param.ContextToParams(cli.NewContext(nil, f, nil)); err == nil {
+			t.Errorf("Expected log sample rate %s to return an error", invalid)
+		}
+	}
+}
+
+func TestContextToParamsTryFiles(t *testing.T) {
+	set := flag.NewFlagSet("a", flag.ContinueOnError)
+	set.Var(cli.NewStringSlice("$uri", "$uri/index.html", "/index.html", "=404"), "try-files", "")
+	params, err := param.ContextToParams(cli.NewContext(nil, set, nil))
+	if err != nil {
+		t.Errorf("Error: %s", err)
+		return
+	}
+	if len(params.TryFiles) != 4 {
+		t.Errorf("Got %v, expected 4 entries", params.TryFiles)
+	}
+
+	for _, invalid := range []string{"index.html", "=abc", "=42"} {
+		set := flag.NewFlagSet("a", flag.ContinueOnError)
+		set.Var(cli.NewStringSlice(invalid), "try-files", "")
+		if _, err := param.ContextToParams(cli.NewContext(nil, set, nil)); err == nil {
+			t.Errorf("Expected %q to return an error", invalid)
+		}
+	}
+}
+
+func TestContextToParamsLogStaticFields(t *testing.T) {
+	set := flag.NewFlagSet("a", flag.ContinueOnError)
+	set.Var(cli.NewStringSlice("team=web", "region=eu=west"), "log-static-fields", "")
+	params, err := param.ContextToParams(cli.NewContext(nil, set, nil))
+	if err != nil {
+		t.Errorf("Error: %s", err)
+		return
+	}
+	if len(params.LogStaticFields) != 2 || params.LogStaticFields["team"] != "web" || params.LogStaticFields["region"] != "eu=west" {
+		t.Errorf("Got %v, expected team and region fields", params.LogStaticFields)
+	}
+
+	for _, invalid := range []string{"team", "=web"} {
+		set := flag.NewFlagSet("a", flag.ContinueOnError)
+		set.Var(cli.NewStringSlice(invalid), "log-static-fields", "")
+		if _, err := param.ContextToParams(cli.NewContext(nil, set, nil)); err == nil {
+			t.Errorf("Expected %q to return an error", invalid)
+		}
+	}
+}
+
+func TestParseCacheControlRules(t *testing.T) {
+	rules, err := param.ParseCacheControlRules("*.js, *.css => public, max-age=31536000, immutable; index.html => no-cache;")
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if len(rules) != 2 || len(rules[0].Patterns) != 2 || rules[0].Value != "public, max-age=31536000, immutable" || rules[1].Value != "no-cache" {
+		t.Errorf("Got %+v, expected two rules", rules)
+	}
+	if !rules[0].Matches("/assets/app.ab12cd.css", "app.ab12cd.css") || rules[0].Matches("/index.html", "index.html") {
+		t.Errorf("Expected %v to match stylesheets only", rules[0].Patterns)
+	}
+
+	for _, invalid := range []string{"*.js", "*.js =>", " => no-cache", "[ => no-cache"} {
+		if _, err := param.ParseCacheControlRules(invalid); err == nil {
+			t.Errorf("Expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestParseUserAgentPatterns(t *testing.T) {
+	patterns, err := param.ParseUserAgentPatterns("(?i)bot{2,};curl/;")
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if len(patterns) != 2 || patterns[0].String() != "(?i)bot{2,}" || patterns[1].String() != "curl/" {
+		t.Errorf("Got %v, expected two patterns keeping their commas", patterns)
+	}
+
+	if _, err := param.ParseUserAgentPatterns("curl/;bot("); err == nil {
+		t.Errorf("Expected an invalid pattern to be rejected")
+	}
+}
+
+func TestContextToParamsTLS(t *testing.T) {
+	set := flag.NewFlagSet("a", flag.ContinueOnError)
+	set.Bool("tls", true, "")
+	set.String("tls-cert", "cert.pem", "")
+	if _, err := param.ContextToParams(cli.NewContext(nil, set, nil)); err == nil {
+		t.Errorf("Expected tls without tls-key to return an error")
+	}
+
+	set.String("tls-key", "key.pem", "")
+	params, err := param.ContextToParams(cli.NewContext(nil, set, nil))
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if !params.TLS || params.TLSCert != "cert.pem" || params.TLSKey != "key.pem" {
+		t.Errorf("Got %v %q %q, expected TLS with both files", params.TLS, params.TLSCert, params.TLSKey)
+	}
+}
+
+func TestParseTLSCertificate(t *testing.T) {
+	cert, err := param.ParseTLSCertificate("b.pem|b-key.pem")
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if cert.CertFile != "b.pem" || cert.KeyFile != "b-key.pem" {
+		t.Errorf("Got %+v, expected b.pem and b-key.pem", cert)
+	}
+
+	for _, invalid := range []string{"b.pem", "b.pem|", "|b-key.pem"} {
+		if _, err := param.ParseTLSCertificate(invalid); err == nil {
+			t.Errorf("Expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestContextToParamsContentSecurityPolicy(t *testing.T) {
+	set := flag.NewFlagSet("a", flag.ContinueOnError)
+	set.String("content-security-policy", "script-src 'nonce-{nonce}'", "")
+	set.String("header-content-security-policy", "default-src 'self'", "")
+	if _, err := param.ContextToParams(cli.NewContext(nil, set, nil)); err == nil {
+		t.Errorf("Expected both content security policies to return an error")
+	}
+}
+
+func TestContextToParamsCORS(t *testing.T) {
+	set := flag.NewFlagSet("a", flag.ContinueOnError)
+	set.Var(cli.NewStringSlice("*"), "cors-allowed-origins", "")
+	set.Bool("cors-allow-credentials", true, "")
+	if _, err := param.ContextToParams(cli.NewContext(nil, set, nil)); err == nil {
+		t.Errorf("Expected the * origin with credentials to return an error")
+	}
+
+	set = flag.NewFlagSet("a", flag.ContinueOnError)
+	set.Var(cli.NewStringSlice("https://app.example.com"), "cors-allowed-origins", "")
+	set.Int("cors-max-age", -1, "")
+	params, err := param.ContextToParams(cli.NewContext(nil, set, nil))
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if params.CORS.MaxAge != -1 {
+		t.Errorf("Got %d, expected a negative cors-max-age to be kept", params.CORS.MaxAge)
+	}
 }
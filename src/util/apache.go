@@ -0,0 +1,66 @@
+package util
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// apacheWriter writes access lines in the Apache Combined Log Format:
+// %h %l %u %t "%r" %>s %b "%{Referer}i" "%{User-Agent}i"
+type apacheWriter struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+func apacheField(s string) string {
+	if s == "" {
+		return "-"
+	}
+	// like mod_log_config, escape quotes and backslashes so fields stay parseable
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s)
+}
+
+func (aw *apacheWriter) write(ri *HTTPReqInfo, start time.Time) {
+	user := "-"
+	if ri.user != "" {
+		user = apacheField(ri.user)
+	}
+	size := "-"
+	if ri.size > 0 {
+		size = strconv.FormatInt(ri.size, 10)
+	}
+	host := "-"
+	if ri.ipAddress != nil {
+		host = ri.ipAddress.String()
+	}
+	code := ri.code
+	if code == 0 {
+		code = http.StatusOK
+	}
+
+	var line strings.Builder
+	line.WriteString(host)
+	line.WriteString(" - ")
+	line.WriteString(user)
+	line.WriteString(" [")
+	line.WriteString(start.Format("02/Jan/2006:15:04:05 -0700"))
+	line.WriteString(`] "`)
+	line.WriteString(apacheField(ri.method + " " + ri.requestURI + " " + ri.proto))
+	line.WriteString(`" `)
+	line.WriteString(strconv.Itoa(code))
+	line.WriteString(" ")
+	line.WriteString(size)
+	line.WriteString(` "`)
+	line.WriteString(apacheField(ri.referer))
+	line.WriteString(`" "`)
+	line.WriteString(apacheField(ri.userAgent))
+	line.WriteString("\"\n")
+
+	aw.mu.Lock()
+	defer aw.mu.Unlock()
+	_, _ = io.WriteString(aw.out, line.String())
+}
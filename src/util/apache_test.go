@@ -0,0 +1,43 @@
+package util
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+func TestLogRequestHandlerApache(t *testing.T) {
+	var buf bytes.Buffer
+	handler := logRequestHandler(&countingHandler{}, &LogRequestHandlerOptions{Format: LogFormatApache}, &buf)
+
+	req := httptest.NewRequest("GET", "/path?q=1", nil)
+	req.RemoteAddr = "127.0.0.1:12345"
+	req.Header.Set("Referer", "https://example.com/")
+	req.Header.Set("User-Agent", `Mozilla/5.0 "quoted"`)
+	req.SetBasicAuth("frank", "secret")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	pattern := regexp.MustCompile(`^127\.0\.0\.1 - frank \[\d{2}/[A-Z][a-z]{2}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] "GET /path\?q=1 HTTP/1\.1" 200 - "https://example\.com/" "Mozilla/5\.0 \\"quoted\\""\n$`)
+	if !pattern.MatchString(buf.String()) {
+		t.Errorf("Unexpected apache line: %q", buf.String())
+	}
+
+	buf.Reset()
+	body := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte("missing"))
+	})
+	logRequestHandler(body, &LogRequestHandlerOptions{Format: LogFormatApache}, &buf).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/x", nil))
+	if !regexp.MustCompile(`^192\.0\.2\.1 - - \[.+\] "GET /x HTTP/1\.1" 404 7 "-" "-"\n$`).MatchString(buf.String()) {
+		t.Errorf("Unexpected apache line: %q", buf.String())
+	}
+
+	buf.Reset()
+	logRequestHandler(&countingHandler{}, &LogRequestHandlerOptions{Format: LogFormatApache, Level: slog.LevelWarn}, &buf).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if buf.Len() != 0 {
+		t.Errorf("Expected no output below WARN, got: %q", buf.String())
+	}
+}
@@ -0,0 +1,125 @@
+package util
+
+import (
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultAsyncBufferSize is used when AsyncBufferSize is not configured
+const DefaultAsyncBufferSize = 1024
+
+// logEntry is an access line waiting to be written
+type logEntry struct {
+	ri    *HTTPReqInfo
+	start time.Time
+	// flushed marks a Flush, closed once the lines queued before it are written
+	flushed chan struct{}
+}
+
+// asyncLogger writes access lines from a single goroutine, in the order the
+// requests finished, so slow log writers do not delay responses
+type asyncLogger struct {
+	http.Handler
+	entries chan logEntry
+	done    chan struct{}
+	dropped atomic.Uint64
+	// guards entries against sends after Close
+	mu     sync.RWMutex
+	closed bool
+}
+
+func newAsyncLogger(size int, write func(logEntry)) *asyncLogger {
+	if size <= 0 {
+		size = DefaultAsyncBufferSize
+	}
+	al := &asyncLogger{entries: make(chan logEntry, size), done: make(chan struct{})}
+	go func() {
+		defer close(al.done)
+		for entry := range al.entries {
+			if entry.flushed != nil {
+				close(entry.flushed)
+				continue
+			}
+			write(entry)
+		}
+	}()
+	return al
+}
+
+// enqueue never blocks, lines are dropped while the buffer is full or after Close
+func (al *asyncLogger) enqueue(entry logEntry) {
+	al.mu.RLock()
+	defer al.mu.RUnlock()
+	if al.closed {
+		al.dropped.Add(1)
+		return
+	}
+	select {
+	case al.entries <- entry:
+	default:
+		al.dropped.Add(1)
+	}
+}
+
+// Flush waits until the lines buffered so far are written, e.g. to capture the
+// latest lines during an incident. Unlike enqueue it blocks while the buffer is full
+func (al *asyncLogger) Flush() {
+	al.mu.RLock()
+	if al.closed {
+		al.mu.RUnlock()
+		return
+	}
+	flushed := make(chan struct{})
+	al.entries <- logEntry{flushed: flushed}
+	al.mu.RUnlock()
+	<-flushed
+}
+
+// FlushLogsOnSignal flushes the access lines buffered by an Async h whenever one
+// of signals is received, e.g. syscall.SIGUSR2. Handlers without Async are left
+// alone, stop ends the notifications
+func FlushLogsOnSignal(h http.Handler, signals ...os.Signal) (stop func()) {
+	al, ok := h.(*asyncLogger)
+	if !ok {
+		return func() {}
+	}
+	c := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(c, signals...)
+	go func() {
+		for {
+			select {
+			case <-c:
+				al.Flush()
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(c)
+		close(done)
+	}
+}
+
+// Dropped returns the number of access lines dropped so far
+func (al *asyncLogger) Dropped() uint64 {
+	return al.dropped.Load()
+}
+
+// Close writes the buffered lines and stops the background goroutine, requests
+// finishing afterwards are served without being logged
+func (al *asyncLogger) Close() error {
+	al.mu.Lock()
+	if !al.closed {
+		al.closed = true
+		close(al.entries)
+	}
+	al.mu.Unlock()
+	<-al.done
+	return nil
+}
@@ -0,0 +1,164 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestLogRequestHandlerAsyncOrder(t *testing.T) {
+	var buf bytes.Buffer
+	handler := logRequestHandler(&countingHandler{}, &LogRequestHandlerOptions{Async: true}, &buf)
+
+	for i := 0; i < 100; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/"+strconv.Itoa(i), nil))
+	}
+	if err := handler.(io.Closer).Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 100 {
+		t.Fatalf("Expected 100 lines, got %d", len(lines))
+	}
+	for i, line := range lines {
+		var logData map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &logData); err != nil {
+			t.Fatalf("Failed to parse log output as JSON: %v\nLog output: %s", err, line)
+		}
+		if logData["path"] != "/"+strconv.Itoa(i) {
+			t.Errorf("Line %d: expected path /%d, got %v", i, i, logData["path"])
+		}
+	}
+	if dropped := handler.(*asyncLogger).Dropped(); dropped != 0 {
+		t.Errorf("Expected no dropped lines, got %d", dropped)
+	}
+}
+
+// blockingWriter holds every write until release is closed
+type blockingWriter struct {
+	started chan struct{}
+	release chan struct{}
+	buf     bytes.Buffer
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	w.started <- struct{}{}
+	<-w.release
+	return w.buf.Write(p)
+}
+
+func TestLogRequestHandlerAsyncDropped(t *testing.T) {
+	out := &blockingWriter{started: make(chan struct{}, 10), release: make(chan struct{})}
+	handler := logRequestHandler(&countingHandler{}, &LogRequestHandlerOptions{Async: true, AsyncBufferSize: 1}, out)
+
+	// the first line is taken by the writer goroutine, the second fills the buffer
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/1", nil))
+	<-out.started
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/2", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/3", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/4", nil))
+
+	async := handler.(*asyncLogger)
+	if dropped := async.Dropped(); dropped != 2 {
+		t.Errorf("Expected 2 dropped lines, got %d", dropped)
+	}
+
+	close(out.release)
+	_ = async.Close()
+	if lines := strings.Count(out.buf.String(), "\n"); lines != 2 {
+		t.Errorf("Expected 2 lines after Close, got %d: %s", lines, out.buf.String())
+	}
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/5", nil))
+	if dropped := async.Dropped(); dropped != 3 {
+		t.Errorf("Expected requests after Close to be dropped, got %d", dropped)
+	}
+}
+
+func TestLogRequestHandlerAsyncFlush(t *testing.T) {
+	out := &blockingWriter{started: make(chan struct{}, 10), release: make(chan struct{})}
+	handler := logRequestHandler(&countingHandler{}, &LogRequestHandlerOptions{Async: true}, out)
+	async := handler.(*asyncLogger)
+	defer async.Close()
+
+	for i := 0; i < 3; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/"+strconv.Itoa(i), nil))
+	}
+	<-out.started
+
+	flushed := make(chan struct{})
+	go func() {
+		async.Flush()
+		close(flushed)
+	}()
+	select {
+	case <-flushed:
+		t.Fatal("Expected Flush to wait for the buffered lines")
+	default:
+	}
+
+	close(out.release)
+	<-flushed
+	if lines := strings.Count(out.buf.String(), "\n"); lines != 3 {
+		t.Errorf("Expected 3 lines once Flush returned, got %d: %s", lines, out.buf.String())
+	}
+}
+
+func TestLogRequestHandlerAsyncApache(t *testing.T) {
+	out := &blockingWriter{started: make(chan struct{}, 10), release: make(chan struct{})}
+	handler := logRequestHandler(&countingHandler{}, &LogRequestHandlerOptions{Async: true, Format: LogFormatApache}, out)
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/1", nil))
+	<-out.started
+	// the line of a reused request is written from what it held when served
+	req := httptest.NewRequest("GET", "/2?a=1", nil)
+	req.SetBasicAuth("alice", "secret")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	req.Method, req.RequestURI, req.Proto = "POST", "/other", "HTTP/2.0"
+	req.Header.Del("Authorization")
+
+	close(out.release)
+	_ = handler.(io.Closer).Close()
+	if !strings.Contains(out.buf.String(), ` - alice [`) || !strings.Contains(out.buf.String(), `"GET /2?a=1 HTTP/1.1"`) {
+		t.Errorf("Expected the line of the request as served, got %s", out.buf.String())
+	}
+}
+
+func TestFlushLogsOnSignal(t *testing.T) {
+	out := &blockingWriter{started: make(chan struct{}, 10), release: make(chan struct{})}
+	handler := logRequestHandler(&countingHandler{}, &LogRequestHandlerOptions{Async: true}, out)
+	async := handler.(*asyncLogger)
+	defer async.Close()
+	stop := FlushLogsOnSignal(handler, os.Interrupt)
+	defer stop()
+
+	// the writer holds the first line, the second waits in the buffer
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/1", nil))
+	<-out.started
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/2", nil))
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGINT); err != nil {
+		t.Fatal(err)
+	}
+	// the signal queues a flush behind the second line
+	deadline := time.Now().Add(5 * time.Second)
+	for len(async.entries) != 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the signal to flush the buffered lines")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(out.release)
+	async.Flush()
+	if lines := strings.Count(out.buf.String(), "\n"); lines != 2 {
+		t.Errorf("Expected 2 lines, got %d: %s", lines, out.buf.String())
+	}
+}
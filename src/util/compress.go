@@ -0,0 +1,306 @@
+package util
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// DefaultCompressThreshold is used when CompressOptions.Threshold is not configured
+const DefaultCompressThreshold = 1024
+
+// DefaultCompressBufferSize is used when CompressOptions.BufferSize is not configured
+const DefaultCompressBufferSize = 64 * 1024
+
+// CompressOptions configure CompressHandler, the zero value uses the defaults
+type CompressOptions struct {
+	// Threshold is the minimum body size in bytes compressed, DefaultCompressThreshold when 0
+	Threshold int
+	// BrotliQuality from 1 (fastest) to 11 (smallest), brotli.DefaultCompression when 0
+	BrotliQuality int
+	// BrotliDenied, when set, picks the clients served gzip even if they accept
+	// br, responses then vary on User-Agent too
+	BrotliDenied func(r *http.Request) bool
+	// Encodings in order of preference, br then gzip when empty. Clients' q-values still win
+	Encodings []string
+	// BufferSize is the largest compressed body held back to be sent with a
+	// Content-Length, larger ones are streamed. DefaultCompressBufferSize when 0,
+	// negative to always stream
+	BufferSize int
+	// MaxConcurrentCompressions bounds the responses compressed at once, the
+	// ones beyond it are served uncompressed rather than queue for a CPU. 0 for no limit
+	MaxConcurrentCompressions int
+}
+
+// compressEncodings are offered in this order unless Encodings are configured
+var compressEncodings = []string{"br", "gzip"}
+
+// incompressibleTypes are media types compressed by their own format
+var incompressibleTypes = []string{
+	"image/", "video/", "audio/", "font/woff", "font/woff2",
+	"application/zip", "application/gzip", "application/x-gzip", "application/x-brotli", "application/pdf",
+}
+
+func compressibleType(contentType string) bool {
+	mediaType, _, _ := strings.Cut(strings.ToLower(contentType), ";")
+	mediaType = strings.TrimSpace(mediaType)
+	if mediaType == "image/svg+xml" {
+		return true
+	}
+	for _, prefix := range incompressibleTypes {
+		if strings.HasPrefix(mediaType, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// CompressHandler compresses the 200 responses of h above the threshold with
+// brotli or gzip, whichever the client prefers, the first of the Encodings on a
+// tie. Responses that already have a Content-Encoding or an already compressed
+// media type are left as is. HEAD responses get the headers of the GET
+// response, judged by the Content-Length h sets
+func CompressHandler(h http.Handler, opt *CompressOptions) http.Handler {
+	threshold := DefaultCompressThreshold
+	quality := brotli.DefaultCompression
+	bufferSize := DefaultCompressBufferSize
+	allEncodings := compressEncodings
+	if opt != nil && opt.Threshold > 0 {
+		threshold = opt.Threshold
+	}
+	if opt != nil && opt.BrotliQuality > 0 {
+		quality = opt.BrotliQuality
+	}
+	if opt != nil && opt.BufferSize != 0 {
+		bufferSize = opt.BufferSize
+	}
+	if opt != nil && len(opt.Encodings) > 0 {
+		allEncodings = opt.Encodings
+	}
+	gzipOnly := slices.DeleteFunc(slices.Clone(allEncodings), func(encoding string) bool { return encoding == "br" })
+	var slots chan struct{}
+	if opt != nil && opt.MaxConcurrentCompressions > 0 {
+		slots = make(chan struct{}, opt.MaxConcurrentCompressions)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		AddVary(w.Header(), "Accept-Encoding")
+		encodings := allEncodings
+		if opt != nil && opt.BrotliDenied != nil {
+			AddVary(w.Header(), "User-Agent")
+			if opt.BrotliDenied(r) {
+				encodings = gzipOnly
+			}
+		}
+		preferred := ParseAcceptEncoding(r.Header.Get("Accept-Encoding")).Preferred(encodings)
+		if len(preferred) == 0 {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, threshold: threshold, encoding: preferred[0], brotliQuality: quality, bufferSize: bufferSize, slots: slots, head: r.Method == http.MethodHead}
+		h.ServeHTTP(cw, r)
+		cw.close()
+	})
+}
+
+// compressWriter holds back the response until threshold bytes were written or
+// the handler returned, then decides whether to compress it
+type compressWriter struct {
+	http.ResponseWriter
+	threshold     int
+	encoding      string
+	brotliQuality int
+	bufferSize    int
+	// compression slots shared by the requests, nil without a limit
+	slots       chan struct{}
+	head        bool
+	code        int
+	wroteHeader bool
+	buf         []byte
+	decided     bool
+	encoder     io.WriteCloser
+	body        *compressedBody
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		// informational responses precede the final one
+		cw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+	cw.code = code
+	// bodiless statuses and responses encoded by h are passed through
+	if code == http.StatusSwitchingProtocols || code == http.StatusNoContent || code == http.StatusNotModified || cw.Header().Get("Content-Encoding") != "" {
+		cw.decided = true
+		cw.ResponseWriter.WriteHeader(code)
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.decided {
+		if cw.encoder != nil {
+			return cw.encoder.Write(p)
+		}
+		return cw.ResponseWriter.Write(p)
+	}
+
+	cw.buf = append(cw.buf, p...)
+	if len(cw.buf) >= cw.threshold {
+		if err := cw.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// decide sends the header and the held back body, compressing it when large
+// enough and eligible
+func (cw *compressWriter) decide(large bool) error {
+	cw.decided = true
+	header := cw.Header()
+	if header.Get("Content-Type") == "" && len(cw.buf) > 0 {
+		// sniff before compressing, the gzip stream would be sniffed otherwise
+		header.Set("Content-Type", http.DetectContentType(cw.buf))
+	}
+
+	if large && cw.compressible() && cw.acquire() {
+		cw.setEncoding()
+		cw.body = &compressedBody{cw: cw}
+		if cw.bufferSize < 0 {
+			_ = cw.body.stream()
+		}
+		if cw.encoding == "br" {
+			cw.encoder = brotli.NewWriterLevel(cw.body, cw.brotliQuality)
+		} else {
+			cw.encoder = gzip.NewWriter(cw.body)
+		}
+		_, err := cw.encoder.Write(cw.buf)
+		cw.buf = nil
+		return err
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.code)
+	var err error
+	if len(cw.buf) > 0 {
+		_, err = cw.ResponseWriter.Write(cw.buf)
+	}
+	cw.buf = nil
+	return err
+}
+
+// compressible reports whether the response is eligible for compression, its
+// size aside
+func (cw *compressWriter) compressible() bool {
+	header := cw.Header()
+	return cw.code == http.StatusOK && header.Get("Content-Encoding") == "" && header.Get("Content-Range") == "" && compressibleType(header.Get("Content-Type"))
+}
+
+// setEncoding sets the headers of a response compressed with the encoding
+func (cw *compressWriter) setEncoding() {
+	header := cw.Header()
+	header.Set("Content-Encoding", cw.encoding)
+	header.Del("Content-Length")
+	// the compressed body is not byte-for-byte the tagged representation any more
+	if etag := header.Get("ETag"); strings.HasPrefix(etag, `"`) {
+		header.Set("ETag", "W/"+etag)
+	}
+}
+
+// acquire takes a compression slot, false when all of them are in use
+func (cw *compressWriter) acquire() bool {
+	if cw.slots == nil {
+		return true
+	}
+	select {
+	case cw.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (cw *compressWriter) close() {
+	if cw.head && !cw.wroteHeader {
+		// the headers of a HEAD response still have to match the GET response
+		cw.WriteHeader(http.StatusOK)
+	}
+	if !cw.wroteHeader {
+		// nothing was written, let the server send its implicit 200
+		return
+	}
+	if !cw.decided && cw.head && len(cw.buf) == 0 {
+		// no body to go by, the GET response is compressed when its length
+		// reaches the threshold. Its compressed length is unknown here
+		cw.decided = true
+		if size, err := strconv.Atoi(cw.Header().Get("Content-Length")); err == nil && size >= cw.threshold && cw.compressible() {
+			cw.setEncoding()
+		}
+		cw.ResponseWriter.WriteHeader(cw.code)
+		return
+	}
+	if !cw.decided {
+		_ = cw.decide(false)
+	}
+	if cw.encoder != nil {
+		_ = cw.encoder.Close()
+		cw.body.finish()
+		if cw.slots != nil {
+			<-cw.slots
+		}
+	}
+}
+
+// compressedBody holds the compressed body back up to bufferSize bytes, so
+// small responses are sent with a Content-Length instead of chunked
+type compressedBody struct {
+	cw        *compressWriter
+	buf       []byte
+	streaming bool
+}
+
+// stream sends the header and whatever was held back, later writes pass through
+func (b *compressedBody) stream() error {
+	b.streaming = true
+	b.cw.ResponseWriter.WriteHeader(b.cw.code)
+	var err error
+	if len(b.buf) > 0 {
+		_, err = b.cw.ResponseWriter.Write(b.buf)
+	}
+	b.buf = nil
+	return err
+}
+
+func (b *compressedBody) Write(p []byte) (int, error) {
+	if b.streaming {
+		return b.cw.ResponseWriter.Write(p)
+	}
+	b.buf = append(b.buf, p...)
+	if len(b.buf) > b.cw.bufferSize {
+		if err := b.stream(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// finish sends a body that was held back completely, with its length
+func (b *compressedBody) finish() {
+	if b.streaming {
+		return
+	}
+	b.cw.Header().Set("Content-Length", strconv.Itoa(len(b.buf)))
+	_ = b.stream()
+}
@@ -0,0 +1,268 @@
+package util
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func TestCompressHandler(t *testing.T) {
+	large := strings.Repeat("console.log('hello')\n", 100)
+	tests := []struct {
+		name           string
+		contentType    string
+		encoding       string
+		body           string
+		acceptEncoding string
+		expected       string
+	}{
+		{"small response", "text/javascript", "", "console.log()", "gzip, br", ""},
+		{"large text response", "text/javascript", "", large, "gzip", "gzip"},
+		{"sniffed text response", "", "", large, "gzip", "gzip"},
+		{"brotli preferred", "text/javascript", "", large, "gzip, deflate, br", "br"},
+		{"brotli only", "text/javascript", "", large, "br", "br"},
+		{"gzip by q-value", "text/javascript", "", large, "br;q=0.5, gzip", "gzip"},
+		{"client without compression", "text/javascript", "", large, "identity", ""},
+		{"image", "image/png", "", large, "gzip", ""},
+		{"svg", "image/svg+xml", "", large, "gzip", "gzip"},
+		{"already encoded", "text/javascript", "br", large, "gzip", "br"},
+	}
+
+	for _, tt := range tests {
+		inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if tt.contentType != "" {
+				w.Header().Set("Content-Type", tt.contentType)
+			}
+			if tt.encoding != "" {
+				w.Header().Set("Content-Encoding", tt.encoding)
+			}
+			w.Header().Set("ETag", `"v1"`)
+			w.Header().Set("Content-Length", strconv.Itoa(len(tt.body)))
+			// written in chunks to cross the threshold mid-response
+			for i := 0; i < len(tt.body); i += 500 {
+				_, _ = io.WriteString(w, tt.body[i:min(i+500, len(tt.body))])
+			}
+		})
+
+		req := httptest.NewRequest("GET", "/app.js", nil)
+		req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+		recorder := httptest.NewRecorder()
+		CompressHandler(inner, &CompressOptions{BrotliQuality: 4}).ServeHTTP(recorder, req)
+
+		if vary := recorder.Header().Get("Vary"); vary != "Accept-Encoding" {
+			t.Errorf("%s: expected Vary Accept-Encoding, got %q", tt.name, vary)
+		}
+		if encoding := recorder.Header().Get("Content-Encoding"); encoding != tt.expected {
+			t.Errorf("%s: expected Content-Encoding %q, got %q", tt.name, tt.expected, encoding)
+			continue
+		}
+		if tt.encoding != "" || tt.expected == "" {
+			if recorder.Body.String() != tt.body || recorder.Header().Get("Content-Length") != strconv.Itoa(len(tt.body)) {
+				t.Errorf("%s: expected the response as is, got %q", tt.name, recorder.Body.String())
+			}
+			continue
+		}
+
+		if etag := recorder.Header().Get("ETag"); etag != `W/"v1"` {
+			t.Errorf("%s: expected a weak ETag, got %q", tt.name, etag)
+		}
+		if contentLength := recorder.Header().Get("Content-Length"); contentLength != strconv.Itoa(recorder.Body.Len()) {
+			t.Errorf("%s: expected the compressed Content-Length %d, got %q", tt.name, recorder.Body.Len(), contentLength)
+		}
+		var reader io.Reader = brotli.NewReader(recorder.Body)
+		if tt.expected == "gzip" {
+			gzipReader, err := gzip.NewReader(recorder.Body)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			reader = gzipReader
+		}
+		if decompressed, _ := io.ReadAll(reader); string(decompressed) != tt.body {
+			t.Errorf("%s: unexpected body %q", tt.name, decompressed)
+		}
+	}
+}
+
+func TestCompressHandlerStatus(t *testing.T) {
+	large := strings.Repeat("a", 2048)
+	for _, code := range []int{http.StatusPartialContent, http.StatusNotFound, http.StatusNotModified} {
+		inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(code)
+			if code != http.StatusNotModified {
+				_, _ = io.WriteString(w, large)
+			}
+		})
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		recorder := httptest.NewRecorder()
+		CompressHandler(inner, nil).ServeHTTP(recorder, req)
+
+		if recorder.Code != code || recorder.Header().Get("Content-Encoding") != "" {
+			t.Errorf("Status %d: expected an uncompressed %d, got %d %q", code, code, recorder.Code, recorder.Header().Get("Content-Encoding"))
+		}
+	}
+}
+
+func TestCompressHandlerBrotliDenied(t *testing.T) {
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = io.WriteString(w, strings.Repeat("a", 2048))
+	})
+	handler := CompressHandler(inner, &CompressOptions{BrotliDenied: func(r *http.Request) bool {
+		return strings.Contains(r.UserAgent(), "OldBrowser")
+	}})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "br, gzip")
+	req.Header.Set("User-Agent", "OldBrowser/1.2")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	if recorder.Header().Get("Content-Encoding") != "gzip" {
+		t.Errorf("Expected gzip for a denied client, got %q", recorder.Header().Get("Content-Encoding"))
+	}
+	if vary := recorder.Header().Get("Vary"); vary != "Accept-Encoding, User-Agent" {
+		t.Errorf("Expected Vary on Accept-Encoding and User-Agent, got %q", vary)
+	}
+}
+
+func TestCompressHandlerEncodings(t *testing.T) {
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = io.WriteString(w, strings.Repeat("a", 2048))
+	})
+	handler := CompressHandler(inner, &CompressOptions{Encodings: []string{"gzip", "br"}})
+
+	tests := []struct {
+		acceptEncoding string
+		expected       string
+	}{
+		{"br, gzip", "gzip"},
+		{"br", "br"},
+		{"gzip;q=0.5, br", "br"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		if encoding := recorder.Header().Get("Content-Encoding"); encoding != tt.expected {
+			t.Errorf("Accept-Encoding %q: expected %q, got %q", tt.acceptEncoding, tt.expected, encoding)
+		}
+	}
+}
+
+func TestCompressHandlerBufferSize(t *testing.T) {
+	// random enough not to compress below the buffer size
+	var body strings.Builder
+	for i := 0; i < 4096; i++ {
+		body.WriteString(strconv.Itoa(i * 7919 % 10007))
+	}
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = io.WriteString(w, body.String())
+	})
+
+	for _, bufferSize := range []int{64, -1} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		recorder := httptest.NewRecorder()
+		CompressHandler(inner, &CompressOptions{BufferSize: bufferSize}).ServeHTTP(recorder, req)
+		if recorder.Header().Get("Content-Encoding") != "gzip" || recorder.Header().Get("Content-Length") != "" {
+			t.Errorf("Buffer size %d: expected a streamed gzip response, got %q with Content-Length %q", bufferSize, recorder.Header().Get("Content-Encoding"), recorder.Header().Get("Content-Length"))
+		}
+		gzipReader, err := gzip.NewReader(recorder.Body)
+		if err != nil {
+			t.Fatalf("Buffer size %d: %v", bufferSize, err)
+		}
+		if decompressed, _ := io.ReadAll(gzipReader); string(decompressed) != body.String() {
+			t.Errorf("Buffer size %d: unexpected body of %d bytes", bufferSize, len(decompressed))
+		}
+	}
+}
+
+func TestCompressHandlerHead(t *testing.T) {
+	for _, size := range []int{100, 2048} {
+		inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Content-Length", strconv.Itoa(size))
+			if r.Method != http.MethodHead {
+				_, _ = io.WriteString(w, strings.Repeat("a", size))
+			}
+		})
+		handler := CompressHandler(inner, nil)
+
+		get := httptest.NewRequest("GET", "/", nil)
+		get.Header.Set("Accept-Encoding", "gzip")
+		getRecorder := httptest.NewRecorder()
+		handler.ServeHTTP(getRecorder, get)
+
+		head := httptest.NewRequest("HEAD", "/", nil)
+		head.Header.Set("Accept-Encoding", "gzip")
+		headRecorder := httptest.NewRecorder()
+		handler.ServeHTTP(headRecorder, head)
+
+		if headRecorder.Header().Get("Content-Encoding") != getRecorder.Header().Get("Content-Encoding") {
+			t.Errorf("Size %d: expected HEAD Content-Encoding %q, got %q", size, getRecorder.Header().Get("Content-Encoding"), headRecorder.Header().Get("Content-Encoding"))
+		}
+		if size > DefaultCompressThreshold && headRecorder.Header().Get("Content-Length") != "" {
+			t.Errorf("Size %d: expected no Content-Length on a compressed HEAD, got %q", size, headRecorder.Header().Get("Content-Length"))
+		}
+		if size < DefaultCompressThreshold && headRecorder.Header().Get("Content-Length") != strconv.Itoa(size) {
+			t.Errorf("Size %d: expected the Content-Length kept, got %q", size, headRecorder.Header().Get("Content-Length"))
+		}
+	}
+}
+
+func TestCompressHandlerMaxConcurrentCompressions(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = io.WriteString(w, strings.Repeat("a", 2048))
+		if r.URL.Path == "/slow" {
+			started <- struct{}{}
+			<-release
+		}
+	})
+	handler := CompressHandler(inner, &CompressOptions{MaxConcurrentCompressions: 1})
+
+	slow := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		req := httptest.NewRequest("GET", "/slow", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		handler.ServeHTTP(slow, req)
+		close(done)
+	}()
+	<-started
+
+	// the only slot is taken, the response degrades to identity
+	req := httptest.NewRequest("GET", "/fast", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	if recorder.Header().Get("Content-Encoding") != "" || recorder.Body.Len() != 2048 {
+		t.Errorf("Expected an uncompressed response beyond the limit, got %q with %d bytes", recorder.Header().Get("Content-Encoding"), recorder.Body.Len())
+	}
+
+	close(release)
+	<-done
+	if slow.Header().Get("Content-Encoding") != "gzip" {
+		t.Errorf("Expected the first response to be compressed, got %q", slow.Header().Get("Content-Encoding"))
+	}
+
+	// the slot is free again once the compressed response is done
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	if recorder.Header().Get("Content-Encoding") != "gzip" {
+		t.Errorf("Expected compression after the slot was released, got %q", recorder.Header().Get("Content-Encoding"))
+	}
+}
@@ -0,0 +1,99 @@
+package util
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+// DefaultCORSMethods are allowed when CORSOptions.AllowedMethods is empty
+var DefaultCORSMethods = []string{http.MethodGet, http.MethodHead, http.MethodOptions}
+
+// CORSOptions configure CORSHandler, CORS is disabled without AllowedOrigins
+type CORSOptions struct {
+	// exact origins like "https://app.example.com", "*" allows any origin
+	AllowedOrigins []string
+	// DefaultCORSMethods when empty
+	AllowedMethods []string
+	// request headers allowed in preflights, none when empty
+	AllowedHeaders []string
+	// AllowCredentials allows credentialed requests from the exact origins,
+	// never from origins only matching "*"
+	AllowCredentials bool
+	// MaxAge in seconds preflight results may be cached, not sent when 0 so
+	// browsers use their default. A negative MaxAge disables caching explicitly
+	MaxAge int
+}
+
+// Validate rejects a wildcard origin with credentials, which would let any
+// site read credentialed responses
+func (opt *CORSOptions) Validate() error {
+	if opt.AllowCredentials && slices.Contains(opt.AllowedOrigins, "*") {
+		return fmt.Errorf("cors credentials cannot be allowed for the * origin, list the origins instead")
+	}
+	return nil
+}
+
+// CORSHandler answers preflight requests with 204 and adds the
+// Access-Control-Allow-* headers for allowed origins to the responses of h,
+// requests from other origins are served by h without them
+func CORSHandler(h http.Handler, opt *CORSOptions) http.Handler {
+	if opt == nil || len(opt.AllowedOrigins) == 0 {
+		return h
+	}
+	methods := opt.AllowedMethods
+	if len(methods) == 0 {
+		methods = DefaultCORSMethods
+	}
+	allowMethods := strings.Join(methods, ", ")
+	allowHeaders := strings.Join(opt.AllowedHeaders, ", ")
+	wildcard := slices.Contains(opt.AllowedOrigins, "*")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		// exact origins are echoed, so responses differ per origin
+		if len(opt.AllowedOrigins) > 1 || !wildcard {
+			AddVary(w.Header(), "Origin")
+		}
+		if preflight {
+			AddVary(w.Header(), "Access-Control-Request-Method", "Access-Control-Request-Headers")
+		}
+
+		exact := origin != "" && slices.Contains(opt.AllowedOrigins, origin)
+		if exact || (origin != "" && wildcard) {
+			header := w.Header()
+			if exact {
+				header.Set("Access-Control-Allow-Origin", origin)
+			} else {
+				header.Set("Access-Control-Allow-Origin", "*")
+			}
+			// credentials are never allowed for an origin only matching "*"
+			if exact && opt.AllowCredentials {
+				header.Set("Access-Control-Allow-Credentials", "true")
+			}
+			if preflight {
+				header.Set("Access-Control-Allow-Methods", allowMethods)
+				if allowHeaders != "" {
+					header.Set("Access-Control-Allow-Headers", allowHeaders)
+				}
+				if opt.MaxAge > 0 {
+					header.Set("Access-Control-Max-Age", strconv.Itoa(opt.MaxAge))
+				} else if opt.MaxAge < 0 {
+					// browsers do not parse negative values, 0 means no caching
+					header.Set("Access-Control-Max-Age", "0")
+				}
+			}
+		}
+
+		if preflight {
+			// without the Allow-* headers the browser fails a disallowed preflight
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
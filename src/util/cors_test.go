@@ -0,0 +1,129 @@
+package util
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestCORSHandler(t *testing.T) {
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("config"))
+	})
+	opt := &CORSOptions{
+		AllowedOrigins:   []string{"https://app.example.com"},
+		AllowedHeaders:   []string{"Authorization", "Content-Type"},
+		AllowCredentials: true,
+		MaxAge:           600,
+	}
+
+	req := httptest.NewRequest("OPTIONS", "/config.json", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", "GET")
+	recorder := httptest.NewRecorder()
+	CORSHandler(inner, opt).ServeHTTP(recorder, req)
+	expected := map[string]string{
+		"Access-Control-Allow-Origin":      "https://app.example.com",
+		"Access-Control-Allow-Methods":     "GET, HEAD, OPTIONS",
+		"Access-Control-Allow-Headers":     "Authorization, Content-Type",
+		"Access-Control-Allow-Credentials": "true",
+		"Access-Control-Max-Age":           "600",
+	}
+	if recorder.Code != http.StatusNoContent || recorder.Body.Len() != 0 {
+		t.Errorf("Expected an empty 204 for a preflight, got %d %q", recorder.Code, recorder.Body.String())
+	}
+	for name, value := range expected {
+		if got := recorder.Header().Get(name); got != value {
+			t.Errorf("Preflight: expected %s %q, got %q", name, value, got)
+		}
+	}
+
+	req = httptest.NewRequest("GET", "/config.json", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	recorder = httptest.NewRecorder()
+	CORSHandler(inner, opt).ServeHTTP(recorder, req)
+	if recorder.Body.String() != "config" || recorder.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" || recorder.Header().Get("Vary") != "Origin" {
+		t.Errorf("Expected the allowed origin to be echoed, got %v", recorder.Header())
+	}
+	if recorder.Header().Get("Access-Control-Allow-Methods") != "" {
+		t.Errorf("Expected no Access-Control-Allow-Methods outside preflights")
+	}
+
+	req = httptest.NewRequest("GET", "/config.json", nil)
+	req.Header.Set("Origin", "https://evil.example.com")
+	recorder = httptest.NewRecorder()
+	CORSHandler(inner, opt).ServeHTTP(recorder, req)
+	if recorder.Body.String() != "config" || recorder.Header().Get("Access-Control-Allow-Origin") != "" || recorder.Header().Get("Access-Control-Allow-Credentials") != "" {
+		t.Errorf("Expected no CORS headers for a disallowed origin, got %v", recorder.Header())
+	}
+}
+
+func TestCORSHandlerWildcard(t *testing.T) {
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	tests := []struct {
+		origins     []string
+		origin      string
+		allowOrigin string
+		credentials string
+	}{
+		{[]string{"*"}, "https://other.example.com", "*", ""},
+		// credentials are only allowed for the listed origins, never via "*"
+		{[]string{"https://app.example.com", "*"}, "https://other.example.com", "*", ""},
+		{[]string{"https://app.example.com", "*"}, "https://app.example.com", "https://app.example.com", "true"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Origin", tt.origin)
+		recorder := httptest.NewRecorder()
+		CORSHandler(inner, &CORSOptions{AllowedOrigins: tt.origins, AllowCredentials: true}).ServeHTTP(recorder, req)
+		if got := recorder.Header().Get("Access-Control-Allow-Origin"); got != tt.allowOrigin {
+			t.Errorf("%v %s: expected Access-Control-Allow-Origin %q, got %q", tt.origins, tt.origin, tt.allowOrigin, got)
+		}
+		if got := recorder.Header().Get("Access-Control-Allow-Credentials"); got != tt.credentials {
+			t.Errorf("%v %s: expected Access-Control-Allow-Credentials %q, got %q", tt.origins, tt.origin, tt.credentials, got)
+		}
+	}
+}
+
+func TestCORSHandlerMaxAge(t *testing.T) {
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	tests := []struct {
+		maxAge   int
+		expected []string
+	}{
+		{600, []string{"600"}},
+		{0, nil},
+		{-1, []string{"0"}},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("OPTIONS", "/config.json", nil)
+		req.Header.Set("Origin", "https://app.example.com")
+		req.Header.Set("Access-Control-Request-Method", "GET")
+		recorder := httptest.NewRecorder()
+		CORSHandler(inner, &CORSOptions{AllowedOrigins: []string{"https://app.example.com"}, MaxAge: tt.maxAge}).ServeHTTP(recorder, req)
+		if got := recorder.Header().Values("Access-Control-Max-Age"); !slices.Equal(got, tt.expected) {
+			t.Errorf("MaxAge %d: expected Access-Control-Max-Age %q, got %q", tt.maxAge, tt.expected, got)
+		}
+	}
+}
+
+func TestCORSOptionsValidate(t *testing.T) {
+	tests := []struct {
+		opt   CORSOptions
+		valid bool
+	}{
+		{CORSOptions{AllowedOrigins: []string{"*"}}, true},
+		{CORSOptions{AllowedOrigins: []string{"https://app.example.com"}, AllowCredentials: true, MaxAge: 600}, true},
+		{CORSOptions{AllowedOrigins: []string{"*"}, AllowCredentials: true}, false},
+		{CORSOptions{AllowedOrigins: []string{"https://app.example.com"}, MaxAge: -1}, true},
+	}
+
+	for _, tt := range tests {
+		if err := tt.opt.Validate(); (err == nil) != tt.valid {
+			t.Errorf("%+v: expected valid %v, got %v", tt.opt, tt.valid, err)
+		}
+	}
+}
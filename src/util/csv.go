@@ -0,0 +1,58 @@
+package util
+
+import (
+	"encoding/csv"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// csvColumns heads the CSV access log, written once before the first row
+var csvColumns = []string{"time", "method", "path", "query", "code", "size", "duration", "ipAddress", "requestId", "userAgent", "referer", "contentType"}
+
+// csvWriter writes one CSV row per request, quoting fields as RFC 4180 requires
+type csvWriter struct {
+	mu          sync.Mutex
+	out         *csv.Writer
+	wroteHeader bool
+}
+
+func newCSVWriter(out io.Writer) *csvWriter {
+	return &csvWriter{out: csv.NewWriter(out)}
+}
+
+func (cw *csvWriter) write(ri *HTTPReqInfo, start time.Time) {
+	code := ri.code
+	if code == 0 {
+		code = http.StatusOK
+	}
+	ipAddress := ""
+	if ri.ipAddress != nil {
+		ipAddress = ri.ipAddress.String()
+	}
+	row := []string{
+		start.UTC().Format(time.RFC3339),
+		ri.method,
+		ri.path,
+		ri.query,
+		strconv.Itoa(code),
+		strconv.FormatInt(ri.size, 10),
+		strconv.FormatInt(ri.durationUnit.value(ri.duration), 10),
+		ipAddress,
+		ri.requestID,
+		ri.userAgent,
+		ri.referer,
+		ri.contentType,
+	}
+
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	if !cw.wroteHeader {
+		_ = cw.out.Write(csvColumns)
+		cw.wroteHeader = true
+	}
+	_ = cw.out.Write(row)
+	cw.out.Flush()
+}
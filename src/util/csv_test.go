@@ -0,0 +1,32 @@
+package util
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestLogRequestHandlerCSV(t *testing.T) {
+	var buf bytes.Buffer
+	handler := logRequestHandler(&countingHandler{}, &LogRequestHandlerOptions{Format: LogFormatCSV}, &buf)
+
+	req := httptest.NewRequest("GET", "/a,b?q=1", nil)
+	req.RemoteAddr = "127.0.0.1:12345"
+	req.Header.Set("User-Agent", `Mozilla/5.0 (X11; Linux x86_64) "quoted", comma`)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Expected a header and two rows, got: %q", buf.String())
+	}
+	if lines[0] != "time,method,path,query,code,size,duration,ipAddress,requestId,userAgent,referer,contentType" {
+		t.Errorf("Unexpected header: %q", lines[0])
+	}
+	pattern := regexp.MustCompile(`^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}Z,GET,"/a,b",q=1,200,0,\d+,127\.0\.0\.1,,"Mozilla/5\.0 \(X11; Linux x86_64\) ""quoted"", comma",,$`)
+	if !pattern.MatchString(lines[1]) {
+		t.Errorf("Unexpected CSV row: %q", lines[1])
+	}
+}
@@ -1,25 +1,33 @@
 package util
 
 import (
+	"fmt"
 	"net"
 	"net/http"
+	"net/textproto"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // Request.RemoteAddress contains port, which we want to remove i.e.:
-// "[::1]:58292" => "[::1]"
+// "[::1]:58292" => "::1"
 func ipAddrFromRemoteAddr(s string) string {
-	// return full string for IPv6 inputs wihtout port
-	if strings.LastIndex(s, "]") == len(s)-1 {
-		return s
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		return host
 	}
+	// no port, IPv6 inputs may still be bracketed
+	return strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+}
 
-	idx := strings.LastIndex(s, ":")
-	if idx == -1 {
-		return s
+// parseForwardedIP parses an X-Forwarded-For entry, a bare IP
+// such as "2001:db8::7" or one with a port
+func parseForwardedIP(s string) net.IP {
+	if ip := net.ParseIP(s); ip != nil {
+		return ip
 	}
-
-	return s[:idx]
+	return net.ParseIP(ipAddrFromRemoteAddr(s))
 }
 
 // requestGetRemoteAddress returns ip address of the client making the request,
@@ -38,7 +46,7 @@ func requestGetRemoteAddress(r *http.Request) net.IP {
 		parts := strings.Split(hdrForwardedFor, ",")
 		fwdIPs := make([]net.IP, len(parts))
 		for i, p := range parts {
-			fwdIPs[i] = net.ParseIP(ipAddrFromRemoteAddr(strings.TrimSpace(p)))
+			fwdIPs[i] = parseForwardedIP(strings.TrimSpace(p))
 		}
 		// return first address
 		return fwdIPs[0]
@@ -46,3 +54,337 @@ func requestGetRemoteAddress(r *http.Request) net.IP {
 
 	return net.ParseIP(hdrRealIP)
 }
+
+// ParseTrustedProxy parses a CIDR, a bare IP matches only itself
+func ParseTrustedProxy(s string) (*net.IPNet, error) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q, expected an IP or CIDR", s)
+		}
+		bits := 8 * len(ip.To16())
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 32
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, network, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trusted proxy %q, expected an IP or CIDR", s)
+	}
+	return network, nil
+}
+
+func isTrusted(ip net.IP, trusted []*net.IPNet) bool {
+	for _, network := range trusted {
+		if ip != nil && network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestGetClientAddress only believes forwarding headers sent by a trusted
+// peer, taking the rightmost X-Forwarded-For entry not added by a trusted
+// proxy, as anything left of it may be made up by the client
+func requestGetClientAddress(r *http.Request, trusted []*net.IPNet) net.IP {
+	if len(trusted) == 0 {
+		return requestGetRemoteAddress(r)
+	}
+
+	peer := net.ParseIP(ipAddrFromRemoteAddr(r.RemoteAddr))
+	if !isTrusted(peer, trusted) {
+		return peer
+	}
+
+	// proxies may append a header line of their own instead of extending the
+	// client's, the lines form one list (RFC 9110, section 5.3)
+	if hdrForwardedFor := strings.Join(r.Header.Values("X-Forwarded-For"), ","); hdrForwardedFor != "" {
+		parts := strings.Split(hdrForwardedFor, ",")
+		for i := len(parts) - 1; i >= 0; i-- {
+			ip := parseForwardedIP(strings.TrimSpace(parts[i]))
+			if ip == nil {
+				break
+			}
+			if !isTrusted(ip, trusted) {
+				return ip
+			}
+		}
+		return peer
+	}
+
+	if ip := net.ParseIP(r.Header.Get("X-Real-Ip")); ip != nil {
+		return ip
+	}
+	return peer
+}
+
+// requestGetRemotePort returns the port of the client making the request, or 0
+// when requestGetClientAddress took the client from the forwarding headers and
+// the client port is unknown. Headers sent by an untrusted peer are ignored there
+// and here alike
+func requestGetRemotePort(r *http.Request, trusted []*net.IPNet) int {
+	forwarded := r.Header.Get("X-Real-Ip") != "" || r.Header.Get("X-Forwarded-For") != ""
+	if forwarded && (len(trusted) == 0 || isTrusted(net.ParseIP(ipAddrFromRemoteAddr(r.RemoteAddr)), trusted)) {
+		return 0
+	}
+
+	_, port, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return 0
+	}
+	remotePort, err := strconv.Atoi(port)
+	if err != nil {
+		return 0
+	}
+	return remotePort
+}
+
+// IfRangeMatches reports whether the Range header applies given the If-Range
+// header, which holds either a strong entity tag or a Last-Modified date. An
+// absent If-Range always matches, a mismatch means the full content is served
+func IfRangeMatches(header string, etag string, modTime time.Time) bool {
+	header = textproto.TrimString(header)
+	if header == "" {
+		return true
+	}
+	if strings.HasPrefix(header, `"`) {
+		// weak tags never match, If-Range requires strong comparison
+		return etag != "" && !strings.HasPrefix(etag, "W/") && header == etag
+	}
+	t, err := http.ParseTime(header)
+	return err == nil && !modTime.IsZero() && modTime.Truncate(time.Second).Equal(t)
+}
+
+// IsByteRange reports whether a Range header value asks for byte ranges, the
+// only unit served, requests for other units get the full content
+func IsByteRange(header string) bool {
+	unit, _, _ := strings.Cut(header, "=")
+	return textproto.TrimString(unit) == "bytes"
+}
+
+// RangeSatisfiable reports whether a Range header value can be served for
+// content of the given size, i.e. at least one of its byte ranges is in
+// bounds (RFC 7233, section 4.4). Malformed or reversed ranges are not
+// satisfiable. Units other than bytes are ignored, those requests are
+// answered in full, so they count as satisfiable
+func RangeSatisfiable(header string, size int64) bool {
+	unit, ranges, ok := strings.Cut(header, "=")
+	if !ok {
+		return false
+	}
+	if textproto.TrimString(unit) != "bytes" {
+		return true
+	}
+
+	satisfiable := false
+	for _, ra := range strings.Split(ranges, ",") {
+		ra = textproto.TrimString(ra)
+		if ra == "" {
+			continue
+		}
+		startStr, endStr, ok := strings.Cut(ra, "-")
+		if !ok {
+			return false
+		}
+		startStr, endStr = textproto.TrimString(startStr), textproto.TrimString(endStr)
+
+		if startStr == "" {
+			// suffix range, i.e. "-500" means the last 500 bytes
+			n, err := strconv.ParseUint(endStr, 10, 63)
+			if err != nil {
+				return false
+			}
+			satisfiable = satisfiable || (n > 0 && size > 0)
+			continue
+		}
+		start, err := strconv.ParseUint(startStr, 10, 63)
+		if err != nil {
+			return false
+		}
+		if endStr != "" {
+			end, err := strconv.ParseUint(endStr, 10, 63)
+			if err != nil || end < start {
+				return false
+			}
+		}
+		satisfiable = satisfiable || int64(start) < size
+	}
+
+	return satisfiable
+}
+
+// CoalesceRanges rewrites a satisfiable byte Range header value for content of
+// the given size into ascending ranges, merging the overlapping and adjacent
+// ones so no part is sent twice (RFC 9110, section 14.2). Out of bounds ranges
+// are dropped. Other values are returned as is
+func CoalesceRanges(header string, size int64) string {
+	unit, ranges, ok := strings.Cut(header, "=")
+	if !ok || textproto.TrimString(unit) != "bytes" || !RangeSatisfiable(header, size) {
+		return header
+	}
+
+	// satisfiable ranges parse, their ends are inclusive
+	var spans [][2]int64
+	for _, ra := range strings.Split(ranges, ",") {
+		ra = textproto.TrimString(ra)
+		if ra == "" {
+			continue
+		}
+		startStr, endStr, _ := strings.Cut(ra, "-")
+		startStr, endStr = textproto.TrimString(startStr), textproto.TrimString(endStr)
+
+		start, end := int64(0), size-1
+		if startStr == "" {
+			n, _ := strconv.ParseInt(endStr, 10, 64)
+			if n == 0 {
+				continue
+			}
+			start = max(size-n, 0)
+		} else {
+			start, _ = strconv.ParseInt(startStr, 10, 64)
+			if endStr != "" {
+				last, _ := strconv.ParseInt(endStr, 10, 64)
+				end = min(last, size-1)
+			}
+		}
+		if start >= size {
+			continue
+		}
+		spans = append(spans, [2]int64{start, end})
+	}
+
+	sort.Slice(spans, func(i, j int) bool { return spans[i][0] < spans[j][0] })
+	merged := spans[:1]
+	for _, span := range spans[1:] {
+		last := &merged[len(merged)-1]
+		if span[0] <= last[1]+1 {
+			last[1] = max(last[1], span[1])
+			continue
+		}
+		merged = append(merged, span)
+	}
+
+	parts := make([]string, len(merged))
+	for i, span := range merged {
+		parts[i] = strconv.FormatInt(span[0], 10) + "-" + strconv.FormatInt(span[1], 10)
+	}
+	return "bytes=" + strings.Join(parts, ",")
+}
+
+// AcceptEncoding maps lower-cased content-codings to their q-values
+type AcceptEncoding map[string]float64
+
+// ParseAcceptEncoding parses an Accept-Encoding header value, codings are
+// case-insensitive and default to q=1
+func ParseAcceptEncoding(header string) AcceptEncoding {
+	accepted := AcceptEncoding{}
+	// cutting instead of splitting saves allocating the parts for every request
+	for rest := header; rest != ""; {
+		var part string
+		part, rest, _ = strings.Cut(rest, ",")
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(textproto.TrimString(coding))
+		if coding == "" {
+			continue
+		}
+
+		q := 1.0
+		for params != "" {
+			var param string
+			param, params, _ = strings.Cut(params, ";")
+			name, value, _ := strings.Cut(param, "=")
+			if strings.ToLower(textproto.TrimString(name)) != "q" {
+				continue
+			}
+			if parsed, err := strconv.ParseFloat(textproto.TrimString(value), 64); err == nil {
+				q = parsed
+			}
+		}
+		accepted[coding] = q
+	}
+	return accepted
+}
+
+// Accepts reports whether the coding is acceptable, falling back to "*"
+func (a AcceptEncoding) Accepts(coding string) bool {
+	return a.quality(coding) > 0
+}
+
+// Preferred returns the acceptable codings among offers, ordered by descending
+// client q-value and by offers order for equal q-values
+func (a AcceptEncoding) Preferred(offers []string) []string {
+	preferred := make([]string, 0, len(offers))
+	for _, offer := range offers {
+		if a.Accepts(offer) {
+			preferred = append(preferred, offer)
+		}
+	}
+	sort.SliceStable(preferred, func(i, j int) bool {
+		return a.quality(preferred[i]) > a.quality(preferred[j])
+	})
+	return preferred
+}
+
+func (a AcceptEncoding) quality(coding string) float64 {
+	if q, ok := a[coding]; ok {
+		return q
+	}
+	return a["*"]
+}
+
+// AcceptsMediaType reports whether an Accept header value explicitly lists the
+// media type with a non-zero q-value, wildcards like "*/*" are not considered
+func AcceptsMediaType(header string, mediaType string) bool {
+	for _, part := range strings.Split(header, ",") {
+		accepted, params, _ := strings.Cut(part, ";")
+		if !strings.EqualFold(textproto.TrimString(accepted), mediaType) {
+			continue
+		}
+
+		for params != "" {
+			var param string
+			param, params, _ = strings.Cut(params, ";")
+			name, value, _ := strings.Cut(param, "=")
+			if strings.ToLower(textproto.TrimString(name)) != "q" {
+				continue
+			}
+			if q, err := strconv.ParseFloat(textproto.TrimString(value), 64); err == nil && q <= 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// AddVary appends tokens to the Vary header of h, skipping ones already listed
+// case-insensitively, so handlers can each declare what they vary on. The
+// header is written as a single comma-separated value
+func AddVary(h http.Header, tokens ...string) {
+	var existing []string
+	for _, value := range h.Values("Vary") {
+		for _, token := range strings.Split(value, ",") {
+			if token = textproto.TrimString(token); token != "" {
+				existing = append(existing, token)
+			}
+		}
+	}
+
+	merged := existing
+	for _, token := range tokens {
+		duplicate := false
+		for _, other := range merged {
+			if strings.EqualFold(token, other) || other == "*" {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			merged = append(merged, token)
+		}
+	}
+	if len(merged) > 0 {
+		h.Set("Vary", strings.Join(merged, ", "))
+	}
+}
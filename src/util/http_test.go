@@ -1,8 +1,12 @@
 package util
 
 import (
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestIPAddrFromRemoteAddr(t *testing.T) {
@@ -10,9 +14,10 @@ func TestIPAddrFromRemoteAddr(t *testing.T) {
 		remoteAddr string
 		expected   string
 	}{
-		{"[::1]:58292", "[::1]"},
+		{"[::1]:58292", "::1"},
 		{"127.0.0.1:12345", "127.0.0.1"},
-		{"[::1]", "[::1]"},
+		{"[::1]", "::1"},
+		{"2001:db8::7", "2001:db8::7"},
 		{"127.0.0.1", "127.0.0.1"},
 	}
 
@@ -49,3 +54,307 @@ func TestRequestGetRemoteAddress(t *testing.T) {
 		}
 	}
 }
+
+func TestRequestGetClientAddress(t *testing.T) {
+	var trusted []*net.IPNet
+	for _, proxy := range []string{"10.0.0.0/8", "192.168.1.5", "fd00::/8"} {
+		network, err := ParseTrustedProxy(proxy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		trusted = append(trusted, network)
+	}
+
+	tests := []struct {
+		name               string
+		headerRealIP       string
+		headerForwardedFor string
+		remoteAddr         string
+		expected           string
+	}{
+		{"direct client", "", "", "203.0.113.7:12345", "203.0.113.7"},
+		{"spoofed header from untrusted peer", "", "1.2.3.4", "203.0.113.7:12345", "203.0.113.7"},
+		{"spoofed real ip from untrusted peer", "1.2.3.4", "", "203.0.113.7:12345", "203.0.113.7"},
+		{"trusted proxy", "", "198.51.100.9", "10.0.0.2:12345", "198.51.100.9"},
+		{"chain through trusted proxies", "", "1.2.3.4, 198.51.100.9, 10.1.1.1", "192.168.1.5:12345", "198.51.100.9"},
+		{"only trusted entries", "", "10.1.1.1, 10.2.2.2", "10.0.0.2:12345", "10.0.0.2"},
+		{"garbage entry stops the walk", "", "198.51.100.9, garbage, 10.1.1.1", "10.0.0.2:12345", "10.0.0.2"},
+		{"real ip from trusted proxy", "198.51.100.9", "", "10.0.0.2:12345", "198.51.100.9"},
+		{"direct IPv6 client", "", "", "[2001:db8::1]:5555", "2001:db8::1"},
+		{"trusted IPv6 proxy", "", "198.51.100.9", "[fd00::2]:5555", "198.51.100.9"},
+		{"bare IPv6 forwarded entry", "", "2001:db8::7", "10.0.0.1:12345", "2001:db8::7"},
+		{"bracketed IPv6 forwarded entry with port", "", "[2001:db8::7]:4711, 10.1.1.1", "10.0.0.1:12345", "2001:db8::7"},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Real-Ip", tt.headerRealIP)
+		req.Header.Set("X-Forwarded-For", tt.headerForwardedFor)
+		req.RemoteAddr = tt.remoteAddr
+
+		actual := requestGetClientAddress(req, trusted)
+		if actual.String() != tt.expected {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.expected, actual)
+		}
+	}
+
+	// the client forges the first line, the trusted proxy appends the second
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Add("X-Forwarded-For", "1.2.3.4")
+	req.Header.Add("X-Forwarded-For", "198.51.100.9")
+	req.RemoteAddr = "10.0.0.2:12345"
+	if actual := requestGetClientAddress(req, trusted); actual.String() != "198.51.100.9" {
+		t.Errorf("Two header lines: expected 198.51.100.9, got %s", actual)
+	}
+
+	for _, invalid := range []string{"10.0.0.0/33", "proxy.local"} {
+		if _, err := ParseTrustedProxy(invalid); err == nil {
+			t.Errorf("Expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestRangeSatisfiable(t *testing.T) {
+	tests := []struct {
+		header   string
+		size     int64
+		expected bool
+	}{
+		{"bytes=0-4", 10, true},
+		{"bytes=5-", 10, true},
+		{"bytes=-3", 10, true},
+		{"bytes=-30", 10, true},
+		{"bytes=0-100", 10, true},
+		{"bytes=0-1, 4-5", 10, true},
+		{"bytes=abc-", 10, false},
+		{"bytes=5-1", 10, false},
+		{"bytes=10-", 10, false},
+		{"bytes=20-30", 10, false},
+		{"bytes=-0", 10, false},
+		{"bytes=0-5,3-8", 10, true},
+		{"bytes=0-4,20-30", 10, true},
+		{"bytes=20-30,-3", 10, true},
+		{"bytes=20-30,30-40", 10, false},
+		{"bytes=0-4,abc", 10, false},
+		{"bytes=+1-2", 10, false},
+		{"bytes=", 10, false},
+		{"bytes=0-0", 0, false},
+		{"items=0-1", 10, true},
+	}
+
+	for _, tt := range tests {
+		actual := RangeSatisfiable(tt.header, tt.size)
+		if actual != tt.expected {
+			t.Errorf("RangeSatisfiable(%s, %d): expected %t, got %t", tt.header, tt.size, tt.expected, actual)
+		}
+	}
+}
+
+func TestCoalesceRanges(t *testing.T) {
+	tests := []struct {
+		header   string
+		size     int64
+		expected string
+	}{
+		{"bytes=0-4", 10, "bytes=0-4"},
+		{"bytes=0-5,3-8", 10, "bytes=0-8"},
+		{"bytes=0-1, 2-3", 10, "bytes=0-3"},
+		{"bytes=6-8,0-1", 10, "bytes=0-1,6-8"},
+		{"bytes=-3,0-100", 10, "bytes=0-9"},
+		{"bytes=5-,-2", 10, "bytes=5-9"},
+		{"bytes=0-4,20-30", 10, "bytes=0-4"},
+		{"bytes=0-1,1-1,4-5", 10, "bytes=0-1,4-5"},
+		{"bytes=20-30", 10, "bytes=20-30"},
+		{"items=0-1", 10, "items=0-1"},
+	}
+
+	for _, tt := range tests {
+		actual := CoalesceRanges(tt.header, tt.size)
+		if actual != tt.expected {
+			t.Errorf("CoalesceRanges(%s, %d): expected %s, got %s", tt.header, tt.size, tt.expected, actual)
+		}
+	}
+}
+
+func TestIfRangeMatches(t *testing.T) {
+	modTime := time.Date(2024, 5, 1, 12, 30, 45, 500, time.UTC)
+	tests := []struct {
+		header   string
+		etag     string
+		expected bool
+	}{
+		{"", `"abc"`, true},
+		{`"abc"`, `"abc"`, true},
+		{`"abc"`, `"def"`, false},
+		{`"abc"`, "", false},
+		{`W/"abc"`, `W/"abc"`, false},
+		{"Wed, 01 May 2024 12:30:45 GMT", "", true},
+		{"Wed, 01 May 2024 12:30:44 GMT", "", false},
+		{"yesterday", "", false},
+	}
+
+	for _, tt := range tests {
+		actual := IfRangeMatches(tt.header, tt.etag, modTime)
+		if actual != tt.expected {
+			t.Errorf("IfRangeMatches(%s, %s): expected %t, got %t", tt.header, tt.etag, tt.expected, actual)
+		}
+	}
+}
+
+func TestParseAcceptEncoding(t *testing.T) {
+	tests := []struct {
+		header string
+		coding string
+		accept bool
+	}{
+		{"gzip", "gzip", true},
+		{"GZIP", "gzip", true},
+		{"Gzip, BR", "br", true},
+		{"br;q=1.0, gzip;q=0.5", "gzip", true},
+		{"br, gzip;q=0", "gzip", false},
+		{"*", "br", true},
+		{"*;q=0", "gzip", false},
+		{"identity", "gzip", false},
+		{"", "gzip", false},
+		{"brotli", "br", false},
+	}
+
+	for _, tt := range tests {
+		actual := ParseAcceptEncoding(tt.header).Accepts(tt.coding)
+		if actual != tt.accept {
+			t.Errorf("ParseAcceptEncoding(%s).Accepts(%s): expected %t, got %t", tt.header, tt.coding, tt.accept, actual)
+		}
+	}
+}
+
+func TestAcceptsMediaType(t *testing.T) {
+	tests := []struct {
+		header   string
+		expected bool
+	}{
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", true},
+		{"TEXT/HTML", true},
+		{"application/json", false},
+		{"*/*", false},
+		{"text/*", false},
+		{"text/html;q=0", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		actual := AcceptsMediaType(tt.header, "text/html")
+		if actual != tt.expected {
+			t.Errorf("AcceptsMediaType(%s, text/html): expected %t, got %t", tt.header, tt.expected, actual)
+		}
+	}
+}
+
+func TestAcceptEncodingPreferred(t *testing.T) {
+	tests := []struct {
+		header   string
+		offers   []string
+		expected []string
+	}{
+		{"br, gzip", []string{"br", "gzip"}, []string{"br", "gzip"}},
+		{"br, gzip", []string{"gzip", "br"}, []string{"gzip", "br"}},
+		{"br;q=0.5, gzip", []string{"br", "gzip"}, []string{"gzip", "br"}},
+		{"gzip;q=0.2, br;q=0.8", []string{"gzip", "br"}, []string{"br", "gzip"}},
+		{"gzip", []string{"br", "gzip"}, []string{"gzip"}},
+		{"*", []string{"gzip", "br"}, []string{"gzip", "br"}},
+		{"identity", []string{"br", "gzip"}, []string{}},
+	}
+
+	for _, tt := range tests {
+		actual := ParseAcceptEncoding(tt.header).Preferred(tt.offers)
+		if strings.Join(actual, ",") != strings.Join(tt.expected, ",") {
+			t.Errorf("Preferred(%s, %v): expected %v, got %v", tt.header, tt.offers, tt.expected, actual)
+		}
+	}
+}
+
+func TestRequestGetRemotePort(t *testing.T) {
+	tests := []struct {
+		headerRealIP       string
+		headerForwardedFor string
+		remoteAddr         string
+		expected           int
+	}{
+		{"", "", "127.0.0.1:12345", 12345},
+		{"", "", "[::1]:58292", 58292},
+		{"", "", "127.0.0.1", 0},
+		{"", "192.168.0.1, 127.0.0.1", "127.0.0.1:12345", 0},
+		{"192.168.0.1", "", "127.0.0.1:12345", 0},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Real-Ip", tt.headerRealIP)
+		req.Header.Set("X-Forwarded-For", tt.headerForwardedFor)
+		req.RemoteAddr = tt.remoteAddr
+		actual := requestGetRemotePort(req, nil)
+		if actual != tt.expected {
+			t.Errorf("requestGetRemotePort(%s, %s, %s): expected %d, got %d", tt.headerRealIP, tt.headerForwardedFor, tt.remoteAddr, tt.expected, actual)
+		}
+	}
+
+	// with trusted proxies only their forwarding headers hide the port
+	trusted := []*net.IPNet{{IP: net.IPv4(10, 0, 0, 0), Mask: net.CIDRMask(8, 32)}}
+	trustedTests := []struct {
+		headerForwardedFor string
+		remoteAddr         string
+		expected           int
+	}{
+		{"192.168.0.1", "10.0.0.2:12345", 0},
+		{"192.168.0.1", "203.0.113.5:12345", 12345},
+		{"", "10.0.0.2:12345", 12345},
+	}
+	for _, tt := range trustedTests {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Forwarded-For", tt.headerForwardedFor)
+		req.RemoteAddr = tt.remoteAddr
+		if actual := requestGetRemotePort(req, trusted); actual != tt.expected {
+			t.Errorf("requestGetRemotePort(%s, %s) with trusted proxies: expected %d, got %d", tt.headerForwardedFor, tt.remoteAddr, tt.expected, actual)
+		}
+	}
+}
+
+func TestAddVary(t *testing.T) {
+	tests := []struct {
+		existing []string
+		tokens   []string
+		expected string
+	}{
+		{nil, []string{"Accept-Encoding"}, "Accept-Encoding"},
+		{[]string{"Accept-Encoding"}, []string{"accept-encoding"}, "Accept-Encoding"},
+		{[]string{"Accept, Accept-Encoding"}, []string{"Origin", "Accept"}, "Accept, Accept-Encoding, Origin"},
+		{[]string{"Accept", "Origin"}, []string{"Accept-Encoding"}, "Accept, Origin, Accept-Encoding"},
+		{[]string{"*"}, []string{"Accept-Encoding"}, "*"},
+	}
+
+	for _, tt := range tests {
+		h := http.Header{}
+		for _, value := range tt.existing {
+			h.Add("Vary", value)
+		}
+		AddVary(h, tt.tokens...)
+		if actual := h.Values("Vary"); len(actual) != 1 || actual[0] != tt.expected {
+			t.Errorf("AddVary(%v, %v): expected %q, got %q", tt.existing, tt.tokens, tt.expected, actual)
+		}
+	}
+}
+
+func TestAddVaryMiddlewares(t *testing.T) {
+	varyOn := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			AddVary(w.Header(), "Accept-Encoding")
+			next.ServeHTTP(w, r)
+		})
+	}
+	handler := varyOn(varyOn(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if vary := w.Header().Values("Vary"); len(vary) != 1 || vary[0] != "Accept-Encoding" {
+		t.Errorf("Expected a single Accept-Encoding token, got %q", vary)
+	}
+}
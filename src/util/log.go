@@ -1,17 +1,224 @@
 package util
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"io"
 	"log/slog"
+	mathrand "math/rand/v2"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/felixge/httpsnoop"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
 )
 
+type LogFormat string
+
+const (
+	LogFormatJSON   LogFormat = "json"
+	LogFormatText   LogFormat = "text"
+	LogFormatLogfmt LogFormat = "logfmt"
+	// LogFormatApache writes Apache Combined Log Format lines, without slog
+	// attributes from the other options
+	LogFormatApache LogFormat = "apache"
+	// LogFormatCSV writes a header row, then a row of the request fields per
+	// request, also without slog attributes from the other options
+	LogFormatCSV LogFormat = "csv"
+)
+
+// ParseLogFormat validates a log format name, an empty name is allowed and
+// means the default format
+func ParseLogFormat(s string) (LogFormat, error) {
+	switch format := LogFormat(s); format {
+	case "", LogFormatJSON, LogFormatText, LogFormatLogfmt, LogFormatApache, LogFormatCSV:
+		return format, nil
+	default:
+		return "", fmt.Errorf("unknown log format %q", s)
+	}
+}
+
+// DurationUnit is the unit of the logged request duration
+type DurationUnit string
+
+const (
+	DurationUnitMilliseconds DurationUnit = "ms"
+	DurationUnitMicroseconds DurationUnit = "us"
+	DurationUnitNanoseconds  DurationUnit = "ns"
+)
+
+// ParseDurationUnit validates a duration unit, empty means milliseconds
+func ParseDurationUnit(s string) (DurationUnit, error) {
+	switch unit := DurationUnit(s); unit {
+	case "", DurationUnitMilliseconds, DurationUnitMicroseconds, DurationUnitNanoseconds:
+		return unit, nil
+	default:
+		return "", fmt.Errorf("unknown duration unit %q, expected one of ms, us or ns", s)
+	}
+}
+
+func (u DurationUnit) value(d time.Duration) int64 {
+	switch u {
+	case DurationUnitMicroseconds:
+		return d.Microseconds()
+	case DurationUnitNanoseconds:
+		return d.Nanoseconds()
+	default:
+		return d.Milliseconds()
+	}
+}
+
 type LogRequestHandlerOptions struct {
+	// Disabled returns the wrapped handler as is, skipping metrics capture,
+	// unless Metrics are kept or RequestID is set
+	Disabled bool
+	// Metrics counts every request not skipped by SkipPaths or SkipPrefixes,
+	// regardless of Disabled, MinDuration and SampleRate
+	Metrics *Metrics
+	// Writer receives the log lines, os.Stdout when nil
+	Writer io.Writer
+	// Pretty is a shorthand for Format = LogFormatText
 	Pretty bool
+	Format LogFormat
+	// MinDuration skips successful requests served faster than it,
+	// errors (status >= 400) are always logged
+	MinDuration time.Duration
+	// SampleRate logs only this fraction (0.0-1.0) of successful requests,
+	// errors (status >= 400) are always logged. 0 and 1 log every request
+	SampleRate float64
+	// RemotePort logs the client port for direct connections
+	RemotePort bool
+	// TrustedProxies are the CIDRs whose forwarding headers are believed for
+	// ipAddress. When empty X-Forwarded-For and X-Real-Ip are always believed
+	TrustedProxies []string
+	// MaxQueryLength truncates longer logged queries, marked with a trailing "...".
+	// No limit when 0
+	MaxQueryLength int
+	// RedactQueryParams are the query parameters logged with the value REDACTED,
+	// matched against the decoded name
+	RedactQueryParams []string
+	// HeaderAttrs maps request headers to log attributes
+	HeaderAttrs []HeaderAttr
+	// MessageKey replaces the "msg" key of access lines
+	MessageKey string
+	// Message replaces the "HTTP Request" message, e.g. with an event type like "http.access"
+	Message string
+	// TraceFormats logs traceId and spanId from the first matching tracing header
+	TraceFormats []TraceFormat
+	// InstanceID is attached to every access line as instanceId
+	InstanceID string
+	// StaticFields are attached to every access line, e.g. team or region tags
+	StaticFields map[string]string
+	// RequestID logs a requestId and echoes it in the RequestIDHeader response
+	// header, reusing a well-formed incoming one. The header is set regardless
+	// of Disabled, SkipPaths and SkipPrefixes
+	RequestID       bool
+	RequestIDHeader string
+	// SkipPaths and SkipPrefixes serve matching request paths without logging them,
+	// matched case-sensitively against the path only
+	SkipPaths    []string
+	SkipPrefixes []string
+	// Redirects logs the Location of 3xx responses as redirectTo, with routeType "redirect"
+	Redirects bool
+	// DurationUnit of the logged duration, milliseconds by default
+	DurationUnit DurationUnit
+	// Level is the minimum level logged, access lines are logged at INFO,
+	// WARN for 4xx and ERROR for 5xx responses
+	Level slog.Level
+	// Async writes access lines from a background goroutine through a buffer of
+	// AsyncBufferSize lines, DefaultAsyncBufferSize by default. Lines are dropped
+	// and counted while the buffer is full. The returned handler then implements
+	// io.Closer, Close writes the buffered lines before returning, and Flush
+	// writes them on demand, see FlushLogsOnSignal
+	Async           bool
+	AsyncBufferSize int
+}
+
+// DefaultRequestIDHeader is used when RequestIDHeader is not configured
+const DefaultRequestIDHeader = "X-Request-Id"
+
+// newRequestID returns a random version 4 UUID
+func newRequestID() string {
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	id[6] = (id[6] & 0x0f) | 0x40
+	id[8] = (id[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:])
+}
+
+// setRequestID echoes the ID of r in header, generating one unless the
+// incoming one is well-formed
+func setRequestID(w http.ResponseWriter, r *http.Request, header string) string {
+	requestID := r.Header.Get(header)
+	if !validRequestID(requestID) {
+		requestID = newRequestID()
+	}
+	w.Header().Set(header, requestID)
+	return requestID
+}
+
+// validRequestID keeps client supplied IDs short and printable so they cannot
+// forge log lines or bloat them
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// NewInstanceID returns a random ID telling apart processes and replicas in logs
+func NewInstanceID() string {
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// HeaderAttr logs the value of a request header as attribute Attr,
+// as a SHA-256 hex digest when Hash is set
+type HeaderAttr struct {
+	Header string
+	Attr   string
+	Hash   bool
+}
+
+// ParseHeaderAttr parses a "<header>:<attr>[:hash]" rule
+func ParseHeaderAttr(s string) (HeaderAttr, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" || (len(parts) == 3 && parts[2] != "hash") {
+		return HeaderAttr{}, fmt.Errorf("invalid header attribute %q, expected <header>:<attr>[:hash]", s)
+	}
+	return HeaderAttr{Header: parts[0], Attr: parts[1], Hash: len(parts) == 3}, nil
+}
+
+func headerAttrs(r *http.Request, rules []HeaderAttr) []slog.Attr {
+	var attrs []slog.Attr
+	for _, rule := range rules {
+		value := r.Header.Get(rule.Header)
+		if value == "" {
+			continue
+		}
+		if rule.Hash {
+			sum := sha256.Sum256([]byte(value))
+			value = hex.EncodeToString(sum[:])
+		}
+		attrs = append(attrs, slog.String(rule.Attr, value))
+	}
+	return attrs
 }
 
 // LogReqInfo describes info about HTTP request
@@ -20,56 +227,416 @@ type HTTPReqInfo struct {
 	method string
 	// requested path
 	path string
+	// raw query string, without the "?"
+	query string
 	// response code, like 200, 404
 	code int
 	// number of bytes of the response sent
 	size int64
 	// how long did it take to
 	duration time.Duration
+	// unit duration is logged in
+	durationUnit DurationUnit
 	// client IP Address
 	ipAddress net.IP
+	// request correlation ID, empty when not enabled
+	requestID string
+	// client port, 0 when unknown or not logged
+	remotePort int
 	// client UserAgent
 	userAgent string
 	// referer header
 	referer string
+	// response Content-Type, empty when none was set
+	contentType string
+	// negotiated TLS version like "TLS 1.3", empty over plain HTTP
+	tlsVersion string
+	// basic auth username, request target with the logged query and protocol
+	// like "HTTP/1.1", only set for the Apache and CSV formats
+	user       string
+	requestURI string
+	proto      string
+	// additional attributes
+	attrs []slog.Attr
 }
 
 func logHTTPReqInfo(l *slog.Logger, ri *HTTPReqInfo) {
-	l.Info("HTTP Request",
+	args := []any{
 		"method", ri.method,
 		"path", ri.path,
+	}
+	if ri.query != "" {
+		args = append(args, "query", ri.query)
+	}
+	args = append(args,
 		slog.Int("code", ri.code),
 		slog.Int64("size", ri.size),
-		slog.Int64("duration", ri.duration.Milliseconds()), // in milliseconds
+		slog.Int64("duration", ri.durationUnit.value(ri.duration)), // in milliseconds by default
 		"ipAddress", ri.ipAddress,
+	)
+	if ri.remotePort != 0 {
+		args = append(args, slog.Int("remotePort", ri.remotePort))
+	}
+	if ri.requestID != "" {
+		args = append(args, "requestId", ri.requestID)
+	}
+	args = append(args,
 		"userAgent", ri.userAgent,
 		"referer", ri.referer,
+		"contentType", ri.contentType,
 	)
+	if ri.tlsVersion != "" {
+		args = append(args, "tlsVersion", ri.tlsVersion)
+	}
+	for _, attr := range ri.attrs {
+		args = append(args, attr)
+	}
+
+	l.Log(context.Background(), levelForStatus(ri.code), "HTTP Request", args...)
+}
+
+// levelForStatus logs client errors at WARN and server errors at ERROR, an
+// unwritten code (0) is an implicit 200
+func levelForStatus(code int) slog.Level {
+	switch {
+	case code >= 500:
+		return slog.LevelError
+	case code >= 400:
+		return slog.LevelWarn
+	default:
+		return slog.LevelInfo
+	}
+}
+
+func newLogger(w io.Writer, opt *LogRequestHandlerOptions) *slog.Logger {
+	format := opt.Format
+	if format == "" && opt.Pretty {
+		format = LogFormatText
+	}
+
+	handlerOpts := &slog.HandlerOptions{Level: opt.Level}
+	if opt.MessageKey != "" || opt.Message != "" {
+		handlerOpts.ReplaceAttr = func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) > 0 || a.Key != slog.MessageKey {
+				return a
+			}
+			if opt.MessageKey != "" {
+				a.Key = opt.MessageKey
+			}
+			if opt.Message != "" {
+				a.Value = slog.StringValue(opt.Message)
+			}
+			return a
+		}
+	}
+
+	switch format {
+	case LogFormatText:
+		return slog.New(slog.NewTextHandler(w, handlerOpts))
+	case LogFormatLogfmt:
+		return slog.New(newLogfmtHandler(w, handlerOpts))
+	default:
+		return slog.New(slog.NewJSONHandler(w, handlerOpts))
+	}
 }
 
+// redactQuery replaces the values of the params in rawQuery, keeping the order
+// and encoding of everything else
+func redactQuery(rawQuery string, params []string) string {
+	if rawQuery == "" || len(params) == 0 {
+		return rawQuery
+	}
+
+	pairs := strings.Split(rawQuery, "&")
+	for i, pair := range pairs {
+		key, _, _ := strings.Cut(pair, "=")
+		name, err := url.QueryUnescape(key)
+		if err != nil {
+			name = key
+		}
+		if slices.Contains(params, name) {
+			pairs[i] = key + "=REDACTED"
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// truncateQuery cuts query to at most limit bytes, backing off to the last
+// complete %XX escape and UTF-8 character, raw or escaped
+func truncateQuery(query string, limit int) string {
+	if limit <= 0 || len(query) <= limit {
+		return query
+	}
+	cut := limit
+	if i := strings.LastIndexByte(query[:cut], '%'); i != -1 && i > cut-3 {
+		cut = i
+	}
+	for cut > 0 && !utf8.RuneStart(query[cut]) {
+		cut--
+	}
+	// walk back over escaped continuation bytes to the escape of their lead byte
+	start := cut
+	for start >= 3 && query[start-3] == '%' {
+		b, ok := unhex(query[start-2 : start])
+		if !ok {
+			break
+		}
+		start -= 3
+		if utf8.RuneStart(b) {
+			if cut-start < 3*escapedRuneLen(b) {
+				cut = start
+			}
+			break
+		}
+	}
+	return query[:cut] + "..."
+}
+
+func unhex(s string) (byte, bool) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return 0, false
+	}
+	return b[0], true
+}
+
+// escapedRuneLen is the length of the UTF-8 sequence starting with lead
+func escapedRuneLen(lead byte) int {
+	switch {
+	case lead >= 0xf0:
+		return 4
+	case lead >= 0xe0:
+		return 3
+	case lead >= 0xc0:
+		return 2
+	}
+	return 1
+}
+
+func skipLogging(urlPath string, opt *LogRequestHandlerOptions) bool {
+	for _, skipped := range opt.SkipPaths {
+		if urlPath == skipped {
+			return true
+		}
+	}
+	for _, prefix := range opt.SkipPrefixes {
+		if strings.HasPrefix(urlPath, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// sampleFloat64 draws the SampleRate decisions, a cheap PRNG is plenty for them
+var sampleFloat64 = mathrand.Float64
+
+type logAttrsKey struct{}
+
+// AddLogAttrs attaches attributes to the access line of r, handlers use it to
+// log details only they know. A no-op when requests are not logged
+func AddLogAttrs(r *http.Request, attrs ...slog.Attr) {
+	if holder, ok := r.Context().Value(logAttrsKey{}).(*[]slog.Attr); ok {
+		*holder = append(*holder, attrs...)
+	}
+}
+
+// captureMetrics is httpsnoop.CaptureMetrics, except that informational
+// responses like 103 Early Hints are not taken for the final status
+func captureMetrics(h http.Handler, w http.ResponseWriter, r *http.Request) httpsnoop.Metrics {
+	m := httpsnoop.Metrics{Code: http.StatusOK}
+	headerWritten := false
+	hooks := httpsnoop.Hooks{
+		WriteHeader: func(next httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+			return func(code int) {
+				next(code)
+				if !headerWritten && (code >= 200 || code == http.StatusSwitchingProtocols) {
+					m.Code = code
+					headerWritten = true
+				}
+			}
+		},
+		Write: func(next httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+			return func(p []byte) (int, error) {
+				n, err := next(p)
+				m.Written += int64(n)
+				headerWritten = true
+				return n, err
+			}
+		},
+		ReadFrom: func(next httpsnoop.ReadFromFunc) httpsnoop.ReadFromFunc {
+			return func(src io.Reader) (int64, error) {
+				n, err := next(src)
+				m.Written += n
+				headerWritten = true
+				return n, err
+			}
+		},
+	}
+
+	start := time.Now()
+	h.ServeHTTP(httpsnoop.Wrap(w, hooks), r)
+	m.Duration = time.Since(start)
+	return m
+}
+
+// LogRequestHandler logs every request served by h, nil options log with the defaults
 func LogRequestHandler(h http.Handler, opt *LogRequestHandlerOptions) http.Handler {
-	var logger *slog.Logger
-	if opt.Pretty {
-		logger = slog.New(slog.NewTextHandler(os.Stdout, nil))
-	} else {
-		logger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	if opt == nil {
+		opt = &LogRequestHandlerOptions{}
+	}
+	out := opt.Writer
+	if out == nil {
+		out = os.Stdout
+	}
+	return logRequestHandler(h, opt, out)
+}
+
+func logRequestHandler(h http.Handler, opt *LogRequestHandlerOptions, out io.Writer) http.Handler {
+	requestIDHeader := opt.RequestIDHeader
+	if requestIDHeader == "" {
+		requestIDHeader = DefaultRequestIDHeader
+	}
+	if opt.Disabled {
+		if opt.Metrics == nil && !opt.RequestID {
+			return h
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if opt.RequestID {
+				setRequestID(w, r, requestIDHeader)
+			}
+			if opt.Metrics == nil || skipLogging(r.URL.Path, opt) {
+				h.ServeHTTP(w, r)
+				return
+			}
+			mtr := captureMetrics(h, w, r)
+			opt.Metrics.observe(r.Method, mtr.Code, mtr.Duration)
+		})
+	}
+
+	logger := newLogger(out, opt)
+	if opt.InstanceID != "" {
+		logger = logger.With("instanceId", opt.InstanceID)
+	}
+	if len(opt.StaticFields) > 0 {
+		keys := maps.Keys(opt.StaticFields)
+		slices.Sort(keys)
+		fields := make([]any, 0, len(keys))
+		for _, key := range keys {
+			fields = append(fields, slog.String(key, opt.StaticFields[key]))
+		}
+		logger = logger.With(fields...)
+	}
+	// formats with fixed fields bypass slog
+	var lines interface {
+		write(ri *HTTPReqInfo, start time.Time)
+	}
+	switch opt.Format {
+	case LogFormatApache:
+		lines = &apacheWriter{out: out}
+	case LogFormatCSV:
+		lines = newCSVWriter(out)
+	}
+	write := func(entry logEntry) {
+		if lines != nil {
+			lines.write(entry.ri, entry.start)
+			return
+		}
+		logHTTPReqInfo(logger, entry.ri)
+	}
+	var async *asyncLogger
+	if opt.Async {
+		async = newAsyncLogger(opt.AsyncBufferSize, write)
+		write = async.enqueue
+	}
+	var trustedProxies []*net.IPNet
+	for _, proxy := range opt.TrustedProxies {
+		// invalid entries are rejected by param.ContextToParams
+		if network, err := ParseTrustedProxy(proxy); err == nil {
+			trustedProxies = append(trustedProxies, network)
+		}
 	}
 
 	fn := func(w http.ResponseWriter, r *http.Request) {
+		var requestID string
+		if opt.RequestID {
+			requestID = setRequestID(w, r, requestIDHeader)
+		}
+
+		if skipLogging(r.URL.Path, opt) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		handlerAttrs := &[]slog.Attr{}
+		r = r.WithContext(context.WithValue(r.Context(), logAttrsKey{}, handlerAttrs))
+
+		start := time.Now()
 		// runs handler h and captures information about HTTP request
-		mtr := httpsnoop.CaptureMetrics(h, w, r)
-
-		logHTTPReqInfo(logger, &HTTPReqInfo{
-			method:    r.Method,
-			path:      r.URL.String(),
-			code:      mtr.Code,
-			size:      mtr.Written,
-			duration:  mtr.Duration,
-			ipAddress: requestGetRemoteAddress(r),
-			userAgent: r.Header.Get("User-Agent"),
-			referer:   r.Header.Get("Referer"),
-		})
+		mtr := captureMetrics(h, w, r)
+		if opt.Metrics != nil {
+			opt.Metrics.observe(r.Method, mtr.Code, mtr.Duration)
+		}
+
+		if mtr.Duration < opt.MinDuration && mtr.Code < 400 {
+			return
+		}
+		if opt.SampleRate > 0 && opt.SampleRate < 1 && mtr.Code < 400 && sampleFloat64() >= opt.SampleRate {
+			return
+		}
+
+		ri := &HTTPReqInfo{
+			method:       r.Method,
+			path:         r.URL.Path,
+			query:        truncateQuery(redactQuery(r.URL.RawQuery, opt.RedactQueryParams), opt.MaxQueryLength),
+			code:         mtr.Code,
+			size:         mtr.Written,
+			duration:     mtr.Duration,
+			durationUnit: opt.DurationUnit,
+			ipAddress:    requestGetClientAddress(r, trustedProxies),
+			userAgent:    r.Header.Get("User-Agent"),
+			referer:      r.Header.Get("Referer"),
+			requestID:    requestID,
+			contentType:  w.Header().Get("Content-Type"),
+		}
+		if r.TLS != nil {
+			ri.tlsVersion = tls.VersionName(r.TLS.Version)
+		}
+		if lines != nil {
+			if levelForStatus(ri.code) >= opt.Level {
+				// copied, an async writer must not read the request once the handler returned
+				if username, _, ok := r.BasicAuth(); ok {
+					ri.user = username
+				}
+				ri.requestURI = r.RequestURI
+				if ri.query != r.URL.RawQuery {
+					ri.requestURI = r.URL.EscapedPath() + "?" + ri.query
+				}
+				ri.proto = r.Proto
+				write(logEntry{ri: ri, start: start})
+			}
+			return
+		}
+		if opt.RemotePort {
+			ri.remotePort = requestGetRemotePort(r, trustedProxies)
+		}
+		if traceID, spanID := traceIDs(r, opt.TraceFormats); traceID != "" {
+			ri.attrs = append(ri.attrs, slog.String("traceId", traceID))
+			if spanID != "" {
+				ri.attrs = append(ri.attrs, slog.String("spanId", spanID))
+			}
+		}
+		ri.attrs = append(ri.attrs, headerAttrs(r, opt.HeaderAttrs)...)
+		if location := w.Header().Get("Location"); opt.Redirects && location != "" && mtr.Code >= 300 && mtr.Code < 400 {
+			ri.attrs = append(ri.attrs, slog.String("redirectTo", location), slog.String("routeType", "redirect"))
+		}
+		ri.attrs = append(ri.attrs, *handlerAttrs...)
+
+		write(logEntry{ri: ri, start: start})
 	}
 
+	if async != nil {
+		async.Handler = http.HandlerFunc(fn)
+		return async
+	}
 	return http.HandlerFunc(fn)
 }
@@ -3,10 +3,14 @@ package util
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"log/slog"
+	mathrand "math/rand/v2"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"regexp"
 	"strings"
 	"testing"
 	"time"
@@ -86,6 +90,7 @@ func TestLogRequestHandler(t *testing.T) {
 		remoteAddr  string
 		wantMethod  string
 		wantPath    string
+		wantQuery   string
 		wantAgent   string
 		wantReferer string
 	}{
@@ -124,7 +129,8 @@ func TestLogRequestHandler(t *testing.T) {
 			referer:     "http://localhost:3000",
 			remoteAddr:  "10.0.0.1:54321",
 			wantMethod:  "PUT",
-			wantPath:    "/api/update?id=123&param=value",
+			wantPath:    "/api/update",
+			wantQuery:   "id=123&param=value",
 			wantAgent:   "Go-http-client/1.1",
 			wantReferer: "http://localhost:3000",
 		},
@@ -156,7 +162,8 @@ func TestLogRequestHandler(t *testing.T) {
 
 				logHTTPReqInfo(logger, &HTTPReqInfo{
 					method:    r.Method,
-					path:      r.URL.String(),
+					path:      r.URL.Path,
+					query:     r.URL.RawQuery,
 					code:      mtr.Code,
 					size:      mtr.Written,
 					duration:  mtr.Duration,
@@ -216,6 +223,11 @@ func TestLogRequestHandler(t *testing.T) {
 				if path, ok := logData["path"]; !ok || path != tt.wantPath {
 					t.Errorf("Expected path %q, got %v", tt.wantPath, path)
 				}
+				if query, ok := logData["query"]; tt.wantQuery != "" && (!ok || query != tt.wantQuery) {
+					t.Errorf("Expected query %q, got %v", tt.wantQuery, query)
+				} else if _, ok := logData["query"]; tt.wantQuery == "" && ok {
+					t.Errorf("Expected no query field, got: %s", logged)
+				}
 				if code, ok := logData["code"]; !ok || code != float64(200) {
 					t.Errorf("Expected code 200, got %v", code)
 				}
@@ -287,7 +299,8 @@ func TestLogRequestHandlerWithDifferentStatusCodes(t *testing.T) {
 
 				logHTTPReqInfo(logger, &HTTPReqInfo{
 					method:    r.Method,
-					path:      r.URL.String(),
+					path:      r.URL.Path,
+					query:     r.URL.RawQuery,
 					code:      mtr.Code,
 					size:      mtr.Written,
 					duration:  mtr.Duration,
@@ -390,3 +403,650 @@ func TestLogRequestHandlerPrettyLogging(t *testing.T) {
 		t.Errorf("Expected log to contain message 'HTTP Request', got: %s", logged)
 	}
 }
+
+type countingHandler struct {
+	calls int
+}
+
+func (h *countingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.calls++
+	w.WriteHeader(http.StatusOK)
+}
+
+func TestLogRequestHandlerDisabled(t *testing.T) {
+	inner := &countingHandler{}
+	handler := LogRequestHandler(inner, &LogRequestHandlerOptions{Disabled: true})
+
+	// the handler must not be wrapped at all, so no metrics are captured and nothing is logged
+	if handler != http.Handler(inner) {
+		t.Fatalf("Expected the inner handler to be returned as is, got %T", handler)
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if inner.calls != 1 || w.Code != http.StatusOK {
+		t.Errorf("Expected inner handler to serve the request, got %d calls and status %d", inner.calls, w.Code)
+	}
+}
+
+func BenchmarkLogRequestHandler(b *testing.B) {
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer devNull.Close()
+	stdout := os.Stdout
+	os.Stdout = devNull
+	defer func() { os.Stdout = stdout }()
+
+	for _, disabled := range []bool{false, true} {
+		handler := LogRequestHandler(&countingHandler{}, &LogRequestHandlerOptions{Disabled: disabled})
+		req := httptest.NewRequest("GET", "/", nil)
+		b.Run(fmt.Sprintf("disabled=%t", disabled), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				handler.ServeHTTP(httptest.NewRecorder(), req)
+			}
+		})
+	}
+}
+
+func TestLogRequestHandlerMinDuration(t *testing.T) {
+	tests := []struct {
+		name       string
+		sleep      time.Duration
+		statusCode int
+		logged     bool
+	}{
+		{"fast request is suppressed", 0, http.StatusOK, false},
+		{"slow request is logged", 30 * time.Millisecond, http.StatusOK, true},
+		{"fast error is logged", 0, http.StatusNotFound, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			dummyHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(tt.sleep)
+				w.WriteHeader(tt.statusCode)
+			})
+			handler := logRequestHandler(dummyHandler, &LogRequestHandlerOptions{MinDuration: 20 * time.Millisecond}, &buf)
+
+			req := httptest.NewRequest("GET", "/", nil)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.statusCode {
+				t.Errorf("Expected status %d, got %d", tt.statusCode, w.Code)
+			}
+			if logged := buf.Len() > 0; logged != tt.logged {
+				t.Errorf("Expected logged = %t, got %t: %s", tt.logged, logged, buf.String())
+			}
+		})
+	}
+}
+
+func TestLogRequestHandlerRemotePort(t *testing.T) {
+	tests := []struct {
+		name         string
+		forwardedFor string
+		wantPort     interface{}
+	}{
+		{"direct connection", "", float64(12345)},
+		{"forwarded connection", "192.168.0.2", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			handler := logRequestHandler(&countingHandler{}, &LogRequestHandlerOptions{RemotePort: true}, &buf)
+
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = "127.0.0.1:12345"
+			if tt.forwardedFor != "" {
+				req.Header.Set("X-Forwarded-For", tt.forwardedFor)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			var logData map[string]interface{}
+			if err := json.Unmarshal(buf.Bytes(), &logData); err != nil {
+				t.Fatalf("Failed to parse log output as JSON: %v\nLog output: %s", err, buf.String())
+			}
+			if port, ok := logData["remotePort"]; port != tt.wantPort || ok != (tt.wantPort != nil) {
+				t.Errorf("Expected remotePort %v, got %v", tt.wantPort, port)
+			}
+		})
+	}
+}
+
+func TestLogRequestHandlerHeaderAttrs(t *testing.T) {
+	var buf bytes.Buffer
+	handler := logRequestHandler(&countingHandler{}, &LogRequestHandlerOptions{
+		HeaderAttrs: []HeaderAttr{
+			{Header: "X-Tenant-ID", Attr: "tenant", Hash: true},
+			{Header: "X-Region", Attr: "region"},
+			{Header: "X-Missing", Attr: "missing"},
+		},
+	}, &buf)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Tenant-ID", "acme")
+	req.Header.Set("X-Region", "eu-west-1")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	var logData map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &logData); err != nil {
+		t.Fatalf("Failed to parse log output as JSON: %v\nLog output: %s", err, buf.String())
+	}
+	// sha256("acme")
+	if tenant := logData["tenant"]; tenant != "822b33ad87c148a0a20a5ba7cd5ebcaa68d36a18e7aad165554903f52ca82757" {
+		t.Errorf("Expected hashed tenant, got %v", tenant)
+	}
+	if region := logData["region"]; region != "eu-west-1" {
+		t.Errorf("Expected region eu-west-1, got %v", region)
+	}
+	if _, ok := logData["missing"]; ok {
+		t.Errorf("Expected no attribute for a missing header, got: %s", buf.String())
+	}
+}
+
+func TestParseHeaderAttr(t *testing.T) {
+	tests := []struct {
+		rule     string
+		expected HeaderAttr
+		valid    bool
+	}{
+		{"X-Tenant-ID:tenant:hash", HeaderAttr{Header: "X-Tenant-ID", Attr: "tenant", Hash: true}, true},
+		{"X-Region:region", HeaderAttr{Header: "X-Region", Attr: "region"}, true},
+		{"X-Region", HeaderAttr{}, false},
+		{"X-Region:region:md5", HeaderAttr{}, false},
+		{":region", HeaderAttr{}, false},
+	}
+
+	for _, tt := range tests {
+		actual, err := ParseHeaderAttr(tt.rule)
+		if (err == nil) != tt.valid || actual != tt.expected {
+			t.Errorf("ParseHeaderAttr(%s): expected %+v (valid %t), got %+v (%v)", tt.rule, tt.expected, tt.valid, actual, err)
+		}
+	}
+}
+
+func TestLogRequestHandlerMessage(t *testing.T) {
+	for _, format := range []LogFormat{LogFormatJSON, LogFormatLogfmt} {
+		t.Run(string(format), func(t *testing.T) {
+			var buf bytes.Buffer
+			handler := logRequestHandler(&countingHandler{}, &LogRequestHandlerOptions{
+				Format:     format,
+				MessageKey: "event",
+				Message:    "http.access",
+			}, &buf)
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+			var logData map[string]string
+			if format == LogFormatJSON {
+				var jsonData map[string]interface{}
+				if err := json.Unmarshal(buf.Bytes(), &jsonData); err != nil {
+					t.Fatalf("Failed to parse log output as JSON: %v\nLog output: %s", err, buf.String())
+				}
+				logData = map[string]string{}
+				for key, value := range jsonData {
+					logData[key] = fmt.Sprint(value)
+				}
+			} else {
+				logData = parseLogfmt(t, strings.TrimSuffix(buf.String(), "\n"))
+			}
+
+			if logData["event"] != "http.access" {
+				t.Errorf("Expected event = http.access, got %q: %s", logData["event"], buf.String())
+			}
+			if _, ok := logData["msg"]; ok {
+				t.Errorf("Expected no msg key, got: %s", buf.String())
+			}
+			if logData["method"] != "GET" {
+				t.Errorf("Expected method = GET, got %q", logData["method"])
+			}
+		})
+	}
+}
+
+func TestLogRequestHandlerInstanceID(t *testing.T) {
+	var buf bytes.Buffer
+	handler := logRequestHandler(&countingHandler{}, &LogRequestHandlerOptions{InstanceID: NewInstanceID()}, &buf)
+
+	for i := 0; i < 2; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 log lines, got: %s", buf.String())
+	}
+	var ids []interface{}
+	for _, line := range lines {
+		var logData map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &logData); err != nil {
+			t.Fatalf("Failed to parse log output as JSON: %v\nLog output: %s", err, line)
+		}
+		ids = append(ids, logData["instanceId"])
+	}
+	if ids[0] == nil || ids[0] == "" || ids[0] != ids[1] {
+		t.Errorf("Expected the same instanceId on every line, got %v", ids)
+	}
+}
+
+func TestAddLogAttrs(t *testing.T) {
+	var buf bytes.Buffer
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		AddLogAttrs(r, slog.String("cacheHit", "yes"))
+	})
+	logRequestHandler(inner, &LogRequestHandlerOptions{}, &buf).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	var logData map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &logData); err != nil {
+		t.Fatalf("Failed to parse log output as JSON: %v\nLog output: %s", err, buf.String())
+	}
+	if logData["cacheHit"] != "yes" {
+		t.Errorf("Expected the handler attribute on the access line, got: %s", buf.String())
+	}
+
+	// without the logging middleware attributes are dropped, not kept for a later access line
+	buf.Reset()
+	req := httptest.NewRequest("GET", "/", nil)
+	AddLogAttrs(req, slog.String("dropped", "yes"))
+	logRequestHandler(&countingHandler{}, &LogRequestHandlerOptions{}, &buf).ServeHTTP(httptest.NewRecorder(), req)
+	logData = map[string]interface{}{}
+	if err := json.Unmarshal(buf.Bytes(), &logData); err != nil {
+		t.Fatalf("Failed to parse log output as JSON: %v\nLog output: %s", err, buf.String())
+	}
+	if _, ok := logData["dropped"]; ok {
+		t.Errorf("Expected the attribute added outside the middleware to be dropped, got: %s", buf.String())
+	}
+}
+
+func TestLogRequestHandlerLevel(t *testing.T) {
+	var buf bytes.Buffer
+	handler := logRequestHandler(&countingHandler{}, &LogRequestHandlerOptions{Level: slog.LevelWarn}, &buf)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if buf.Len() != 0 {
+		t.Errorf("Expected no output below WARN, got: %s", buf.String())
+	}
+
+	notFound := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	logRequestHandler(notFound, &LogRequestHandlerOptions{Level: slog.LevelWarn}, &buf).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if !strings.Contains(buf.String(), `"level":"WARN"`) {
+		t.Errorf("Expected the 404 logged at WARN, got: %s", buf.String())
+	}
+}
+
+func TestLevelForStatus(t *testing.T) {
+	tests := []struct {
+		code     int
+		expected slog.Level
+	}{
+		{0, slog.LevelInfo},
+		{200, slog.LevelInfo},
+		{301, slog.LevelInfo},
+		{404, slog.LevelWarn},
+		{499, slog.LevelWarn},
+		{500, slog.LevelError},
+		{503, slog.LevelError},
+	}
+
+	for _, tt := range tests {
+		if actual := levelForStatus(tt.code); actual != tt.expected {
+			t.Errorf("levelForStatus(%d): expected %s, got %s", tt.code, tt.expected, actual)
+		}
+	}
+}
+
+func TestLogRequestHandlerRedirects(t *testing.T) {
+	redirect := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "https://example.com"+r.URL.Path, http.StatusMovedPermanently)
+	})
+
+	for _, enabled := range []bool{true, false} {
+		var buf bytes.Buffer
+		handler := logRequestHandler(redirect, &LogRequestHandlerOptions{Redirects: enabled}, &buf)
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://example.com/login", nil))
+
+		var logData map[string]interface{}
+		if err := json.Unmarshal(buf.Bytes(), &logData); err != nil {
+			t.Fatalf("Failed to parse log output as JSON: %v\nLog output: %s", err, buf.String())
+		}
+		if enabled && (logData["redirectTo"] != "https://example.com/login" || logData["routeType"] != "redirect") {
+			t.Errorf("Expected the redirect target to be logged, got: %s", buf.String())
+		}
+		if !enabled && logData["redirectTo"] != nil {
+			t.Errorf("Expected no redirect target when disabled, got: %s", buf.String())
+		}
+	}
+
+	var buf bytes.Buffer
+	handler := logRequestHandler(&countingHandler{}, &LogRequestHandlerOptions{Redirects: true}, &buf)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if strings.Contains(buf.String(), "redirectTo") {
+		t.Errorf("Expected no redirect target for a 200, got: %s", buf.String())
+	}
+}
+
+func TestLogRequestHandlerWriter(t *testing.T) {
+	var buf bytes.Buffer
+	handler := LogRequestHandler(&countingHandler{}, &LogRequestHandlerOptions{Writer: &buf})
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if !strings.Contains(buf.String(), `"msg":"HTTP Request"`) {
+		t.Errorf("Expected the access line in the configured writer, got: %s", buf.String())
+	}
+}
+
+func TestLogRequestHandlerNilOptions(t *testing.T) {
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer devNull.Close()
+	stdout := os.Stdout
+	os.Stdout = devNull
+	defer func() { os.Stdout = stdout }()
+
+	inner := &countingHandler{}
+	w := httptest.NewRecorder()
+	LogRequestHandler(inner, nil).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if inner.calls != 1 || w.Code != http.StatusOK {
+		t.Errorf("Expected inner handler to serve the request, got %d calls and status %d", inner.calls, w.Code)
+	}
+}
+
+func TestLogRequestHandlerSkip(t *testing.T) {
+	opt := &LogRequestHandlerOptions{SkipPaths: []string{"/healthz"}, SkipPrefixes: []string{"/assets/"}}
+	tests := []struct {
+		target string
+		logged bool
+	}{
+		{"/healthz", false},
+		{"/healthz?probe=lb", false},
+		{"/HEALTHZ", true},
+		{"/healthz/deep", true},
+		{"/assets/app.js", false},
+		{"/assets", true},
+		{"/", true},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		inner := &countingHandler{}
+		w := httptest.NewRecorder()
+		logRequestHandler(inner, opt, &buf).ServeHTTP(w, httptest.NewRequest("GET", tt.target, nil))
+		if inner.calls != 1 || w.Code != http.StatusOK {
+			t.Errorf("%s: expected inner handler to serve the request, got %d calls and status %d", tt.target, inner.calls, w.Code)
+		}
+		if logged := buf.Len() > 0; logged != tt.logged {
+			t.Errorf("%s: expected logged %t, got: %s", tt.target, tt.logged, buf.String())
+		}
+	}
+}
+
+func TestLogHTTPReqInfoDurationUnit(t *testing.T) {
+	tests := []struct {
+		unit     DurationUnit
+		expected float64
+	}{
+		{"", 0},
+		{DurationUnitMilliseconds, 0},
+		{DurationUnitMicroseconds, 150},
+		{DurationUnitNanoseconds, 150000},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		logHTTPReqInfo(slog.New(slog.NewJSONHandler(&buf, nil)), &HTTPReqInfo{duration: 150 * time.Microsecond, durationUnit: tt.unit})
+
+		var logData map[string]interface{}
+		if err := json.Unmarshal(buf.Bytes(), &logData); err != nil {
+			t.Fatalf("Failed to parse log output as JSON: %v\nLog output: %s", err, buf.String())
+		}
+		if logData["duration"] != tt.expected {
+			t.Errorf("Unit %q: expected duration %v, got %v", tt.unit, tt.expected, logData["duration"])
+		}
+	}
+
+	if _, err := ParseDurationUnit("s"); err == nil {
+		t.Errorf("Expected unknown duration unit to be rejected")
+	}
+}
+
+func TestLogRequestHandlerRequestID(t *testing.T) {
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	tests := []struct {
+		name     string
+		header   string
+		incoming string
+		reused   bool
+	}{
+		{"generated", "", "", false},
+		{"passthrough", "", "abc-123", true},
+		{"custom header passthrough", "X-Correlation-Id", "abc-123", true},
+		{"invalid incoming", "", "abc 123\n", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := tt.header
+			if header == "" {
+				header = DefaultRequestIDHeader
+			}
+			var buf bytes.Buffer
+			handler := logRequestHandler(&countingHandler{}, &LogRequestHandlerOptions{RequestID: true, RequestIDHeader: tt.header}, &buf)
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.incoming != "" {
+				req.Header.Set(header, tt.incoming)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			var logData map[string]interface{}
+			if err := json.Unmarshal(buf.Bytes(), &logData); err != nil {
+				t.Fatalf("Failed to parse log output as JSON: %v\nLog output: %s", err, buf.String())
+			}
+			echoed := w.Header().Get(header)
+			if logData["requestId"] != echoed {
+				t.Errorf("Expected the logged requestId %v to match the %s header %q", logData["requestId"], header, echoed)
+			}
+			if tt.reused && echoed != tt.incoming {
+				t.Errorf("Expected incoming ID %q to be reused, got %q", tt.incoming, echoed)
+			}
+			if !tt.reused && !uuid.MatchString(echoed) {
+				t.Errorf("Expected a generated UUID, got %q", echoed)
+			}
+		})
+	}
+}
+
+func TestLogRequestHandlerRequestIDNotLogged(t *testing.T) {
+	tests := []struct {
+		name string
+		opt  *LogRequestHandlerOptions
+		path string
+	}{
+		{"disabled", &LogRequestHandlerOptions{Disabled: true, RequestID: true}, "/"},
+		{"disabled with metrics", &LogRequestHandlerOptions{Disabled: true, RequestID: true, Metrics: NewMetrics()}, "/"},
+		{"skipped path", &LogRequestHandlerOptions{RequestID: true, SkipPaths: []string{"/health"}}, "/health"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			handler := logRequestHandler(&countingHandler{}, tt.opt, &buf)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+			if w.Header().Get(DefaultRequestIDHeader) == "" {
+				t.Errorf("Expected a %s header", DefaultRequestIDHeader)
+			}
+			if buf.Len() != 0 {
+				t.Errorf("Expected no log line, got: %s", buf.String())
+			}
+		})
+	}
+}
+
+func TestLogRequestHandlerContentType(t *testing.T) {
+	tests := []struct {
+		contentType string
+	}{
+		{"text/plain; charset=utf-8"},
+		{""},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if tt.contentType != "" {
+				w.Header().Set("Content-Type", tt.contentType)
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+		logRequestHandler(inner, &LogRequestHandlerOptions{}, &buf).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+		var logData map[string]interface{}
+		if err := json.Unmarshal(buf.Bytes(), &logData); err != nil {
+			t.Fatalf("Failed to parse log output as JSON: %v\nLog output: %s", err, buf.String())
+		}
+		if contentType, ok := logData["contentType"]; !ok || contentType != tt.contentType {
+			t.Errorf("Expected contentType %q, got: %s", tt.contentType, buf.String())
+		}
+	}
+}
+
+func TestLogRequestHandlerSampleRate(t *testing.T) {
+	rng := mathrand.New(mathrand.NewPCG(1, 2))
+	sampleFloat64 = rng.Float64
+	defer func() { sampleFloat64 = mathrand.Float64 }()
+
+	var buf bytes.Buffer
+	handler := logRequestHandler(&countingHandler{}, &LogRequestHandlerOptions{SampleRate: 0.1}, &buf)
+	for i := 0; i < 10000; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/asset.js", nil))
+	}
+	if lines := strings.Count(buf.String(), "\n"); lines < 900 || lines > 1100 {
+		t.Errorf("Expected about 1000 sampled lines, got %d", lines)
+	}
+
+	buf.Reset()
+	failing := logRequestHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}), &LogRequestHandlerOptions{SampleRate: 0.1}, &buf)
+	for i := 0; i < 100; i++ {
+		failing.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/missing.js", nil))
+	}
+	if lines := strings.Count(buf.String(), "\n"); lines != 100 {
+		t.Errorf("Expected every error to be logged, got %d lines", lines)
+	}
+
+	// a full rate logs everything without drawing
+	sampleFloat64 = func() float64 {
+		t.Fatal("Expected no sampling at rate 1")
+		return 0
+	}
+	buf.Reset()
+	handler = logRequestHandler(&countingHandler{}, &LogRequestHandlerOptions{SampleRate: 1}, &buf)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/asset.js", nil))
+	if lines := strings.Count(buf.String(), "\n"); lines != 1 {
+		t.Errorf("Expected the request to be logged at rate 1, got %d lines", lines)
+	}
+}
+
+func TestRedactQuery(t *testing.T) {
+	tests := []struct {
+		rawQuery string
+		expected string
+	}{
+		{"access_token=abc&page=2", "access_token=REDACTED&page=2"},
+		{"page=2&access_token=abc&sort=desc", "page=2&access_token=REDACTED&sort=desc"},
+		{"access%5Ftoken=abc", "access%5Ftoken=REDACTED"},
+		{"access_token", "access_token=REDACTED"},
+		{"token=abc&q=a%20b", "token=abc&q=a%20b"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		actual := redactQuery(tt.rawQuery, []string{"access_token"})
+		if actual != tt.expected {
+			t.Errorf("redactQuery(%s): expected %s, got %s", tt.rawQuery, tt.expected, actual)
+		}
+	}
+}
+
+func TestTruncateQuery(t *testing.T) {
+	tests := []struct {
+		query    string
+		limit    int
+		expected string
+	}{
+		{"q=abcdef", 0, "q=abcdef"},
+		{"q=abcdef", 8, "q=abcdef"},
+		{"q=abcdef", 5, "q=abc..."},
+		{"q=a%20b", 4, "q=a..."},
+		{"q=a%20b", 5, "q=a..."},
+		{"q=a%20b", 6, "q=a%20..."},
+		{"q=a€b", 5, "q=a..."},
+		{"q=a€b", 6, "q=a€..."},
+		{"q=a%E2%82%ACb", 9, "q=a..."},
+		{"q=a%E2%82%ACb", 12, "q=a%E2%82%AC..."},
+		{"q=%C3%A9%C3%A9", 11, "q=%C3%A9..."},
+	}
+
+	for _, tt := range tests {
+		actual := truncateQuery(tt.query, tt.limit)
+		if actual != tt.expected {
+			t.Errorf("truncateQuery(%q, %d): expected %q, got %q", tt.query, tt.limit, tt.expected, actual)
+		}
+	}
+}
+
+func TestLogRequestHandlerRedactQueryParams(t *testing.T) {
+	var served string
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served = r.URL.RawQuery
+	})
+
+	var buf bytes.Buffer
+	handler := logRequestHandler(inner, &LogRequestHandlerOptions{RedactQueryParams: []string{"access_token"}}, &buf)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/callback?access_token=abc&page=2", nil))
+
+	var logData map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &logData); err != nil {
+		t.Fatalf("Failed to parse log output as JSON: %v\nLog output: %s", err, buf.String())
+	}
+	if logData["query"] != "access_token=REDACTED&page=2" {
+		t.Errorf("Expected redacted query, got: %s", buf.String())
+	}
+	if served != "access_token=abc&page=2" {
+		t.Errorf("Expected the handler to see the original query, got %s", served)
+	}
+
+	buf.Reset()
+	apache := logRequestHandler(inner, &LogRequestHandlerOptions{Format: LogFormatApache, RedactQueryParams: []string{"access_token"}}, &buf)
+	apache.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/callback?access_token=abc&page=2", nil))
+	if !strings.Contains(buf.String(), `"GET /callback?access_token=REDACTED&page=2 HTTP/1.1"`) {
+		t.Errorf("Expected redacted apache request line, got: %s", buf.String())
+	}
+}
+
+func TestLogRequestHandlerStaticFields(t *testing.T) {
+	var buf bytes.Buffer
+	fields := map[string]string{"team": "web", "region": "eu-west-1"}
+	handler := logRequestHandler(&countingHandler{}, &LogRequestHandlerOptions{StaticFields: fields}, &buf)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	var logData map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &logData); err != nil {
+		t.Fatalf("Failed to parse log output as JSON: %v\nLog output: %s", err, buf.String())
+	}
+	for key, value := range fields {
+		if logData[key] != value {
+			t.Errorf("Expected %s=%s, got: %s", key, value, buf.String())
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package util
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// logfmtHandler is a slog.Handler writing records as logfmt key=value lines,
+// i.e. `time=2024-01-02T15:04:05Z level=INFO msg="HTTP Request" method=GET`
+type logfmtHandler struct {
+	w      io.Writer
+	mu     *sync.Mutex
+	opts   slog.HandlerOptions
+	attrs  []byte
+	groups []string
+}
+
+func newLogfmtHandler(w io.Writer, opts *slog.HandlerOptions) *logfmtHandler {
+	h := &logfmtHandler{w: w, mu: &sync.Mutex{}}
+	if opts != nil {
+		h.opts = *opts
+	}
+	return h
+}
+
+func (h *logfmtHandler) Enabled(_ context.Context, level slog.Level) bool {
+	minLevel := slog.LevelInfo
+	if h.opts.Level != nil {
+		minLevel = h.opts.Level.Level()
+	}
+	return level >= minLevel
+}
+
+func (h *logfmtHandler) Handle(_ context.Context, r slog.Record) error {
+	buf := make([]byte, 0, 256)
+	if !r.Time.IsZero() {
+		buf = h.appendAttr(buf, nil, slog.Time(slog.TimeKey, r.Time))
+	}
+	buf = h.appendAttr(buf, nil, slog.Any(slog.LevelKey, r.Level))
+	buf = h.appendAttr(buf, nil, slog.String(slog.MessageKey, r.Message))
+	buf = append(buf, h.attrs...)
+	r.Attrs(func(a slog.Attr) bool {
+		buf = h.appendAttr(buf, h.groups, a)
+		return true
+	})
+	buf = append(buf, '\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	// every pair is written with a leading space separator
+	_, err := h.w.Write(buf[1:])
+	return err
+}
+
+func (h *logfmtHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.attrs = append([]byte{}, h.attrs...)
+	for _, a := range attrs {
+		h2.attrs = h.appendAttr(h2.attrs, h.groups, a)
+	}
+	return &h2
+}
+
+func (h *logfmtHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	h2 := *h
+	h2.groups = append(append([]string{}, h.groups...), name)
+	return &h2
+}
+
+func (h *logfmtHandler) appendAttr(buf []byte, groups []string, a slog.Attr) []byte {
+	a.Value = a.Value.Resolve()
+	if h.opts.ReplaceAttr != nil && a.Value.Kind() != slog.KindGroup {
+		a = h.opts.ReplaceAttr(groups, a)
+		a.Value = a.Value.Resolve()
+	}
+	if a.Equal(slog.Attr{}) {
+		return buf
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			groups = append(append([]string{}, groups...), a.Key)
+		}
+		for _, ga := range a.Value.Group() {
+			buf = h.appendAttr(buf, groups, ga)
+		}
+		return buf
+	}
+
+	var value string
+	if a.Value.Kind() == slog.KindTime {
+		value = a.Value.Time().Format(time.RFC3339Nano)
+	} else {
+		value = a.Value.String()
+	}
+
+	key := a.Key
+	if len(groups) > 0 {
+		key = strings.Join(groups, ".") + "." + key
+	}
+	return appendLogfmtPair(buf, key, value)
+}
+
+func appendLogfmtPair(buf []byte, key string, value string) []byte {
+	buf = append(buf, ' ')
+	buf = append(buf, key...)
+	buf = append(buf, '=')
+	if logfmtNeedsQuoting(value) {
+		return strconv.AppendQuote(buf, value)
+	}
+	return append(buf, value...)
+}
+
+// values containing spaces, quotes, '=' or control characters are quoted
+func logfmtNeedsQuoting(s string) bool {
+	if s == "" {
+		return true
+	}
+	return strings.IndexFunc(s, func(r rune) bool {
+		return r == ' ' || r == '=' || r == '"' || r == '\\' || unicode.IsControl(r) || r == unicode.ReplacementChar
+	}) != -1
+}
@@ -0,0 +1,114 @@
+package util
+
+import (
+	"bytes"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// parseLogfmt splits a single logfmt line into its key/value pairs
+func parseLogfmt(t *testing.T, line string) map[string]string {
+	t.Helper()
+	pairs := map[string]string{}
+	for line != "" {
+		key, rest, ok := strings.Cut(line, "=")
+		if !ok {
+			t.Fatalf("Expected key=value pair, got %q", line)
+		}
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			quoted, err := strconv.QuotedPrefix(rest)
+			if err != nil {
+				t.Fatalf("Failed to parse quoted value %q: %v", rest, err)
+			}
+			value, _ = strconv.Unquote(quoted)
+			rest = rest[len(quoted):]
+		} else {
+			value, rest, _ = strings.Cut(rest, " ")
+			rest = " " + rest
+		}
+		pairs[key] = value
+		line = strings.TrimPrefix(rest, " ")
+	}
+	return pairs
+}
+
+func TestLogfmtHandler(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(newLogfmtHandler(&buf, nil))
+
+	logHTTPReqInfo(logger, &HTTPReqInfo{
+		method:    "GET",
+		path:      "/test/path?q=a b",
+		code:      200,
+		size:      1234,
+		duration:  150 * time.Millisecond,
+		ipAddress: net.ParseIP("127.0.0.1"),
+		userAgent: "Mozilla/5.0 (X11; Linux x86_64)",
+		referer:   "",
+	})
+
+	logged := buf.String()
+	if strings.Count(logged, "\n") != 1 || !strings.HasSuffix(logged, "\n") {
+		t.Fatalf("Expected a single logfmt line, got %q", logged)
+	}
+
+	pairs := parseLogfmt(t, strings.TrimSuffix(logged, "\n"))
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"level", "INFO"},
+		{"msg", "HTTP Request"},
+		{"method", "GET"},
+		{"path", "/test/path?q=a b"},
+		{"code", "200"},
+		{"size", "1234"},
+		{"duration", "150"},
+		{"ipAddress", "127.0.0.1"},
+		{"userAgent", "Mozilla/5.0 (X11; Linux x86_64)"},
+		{"referer", ""},
+	}
+	for _, tt := range tests {
+		if value, ok := pairs[tt.key]; !ok {
+			t.Errorf("Expected log to contain key %q, got: %s", tt.key, logged)
+		} else if value != tt.want {
+			t.Errorf("Expected key %q to be %q, got %q", tt.key, tt.want, value)
+		}
+	}
+	if _, err := time.Parse(time.RFC3339Nano, pairs["time"]); err != nil {
+		t.Errorf("Expected time to be RFC3339, got %q", pairs["time"])
+	}
+
+	if !strings.Contains(logged, `msg="HTTP Request"`) || !strings.Contains(logged, `userAgent="Mozilla/5.0 (X11; Linux x86_64)"`) {
+		t.Errorf("Expected values with spaces to be quoted, got: %s", logged)
+	}
+}
+
+func TestLogfmtHandlerWithAttrsAndGroup(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(newLogfmtHandler(&buf, nil)).With("service", "spa").WithGroup("req")
+	logger.Info("hello", "path", "/", slog.Group("tls", "version", "1.3"))
+
+	pairs := parseLogfmt(t, strings.TrimSuffix(buf.String(), "\n"))
+	for key, want := range map[string]string{"service": "spa", "req.path": "/", "req.tls.version": "1.3"} {
+		if pairs[key] != want {
+			t.Errorf("Expected key %q to be %q, got %q (%s)", key, want, pairs[key], buf.String())
+		}
+	}
+}
+
+func TestParseLogFormat(t *testing.T) {
+	for _, name := range []string{"", "json", "text", "logfmt"} {
+		if _, err := ParseLogFormat(name); err != nil {
+			t.Errorf("Expected %q to be a valid log format, got %s", name, err)
+		}
+	}
+	if _, err := ParseLogFormat("xml"); err == nil {
+		t.Errorf("Expected xml to be rejected")
+	}
+}
@@ -0,0 +1,115 @@
+package util
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultDurationBuckets are the upper bounds in seconds of the
+// http_request_duration_seconds histogram
+var DefaultDurationBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// metricsMethods are labeled as is, anything else as OTHER so clients cannot
+// create series at will
+var metricsMethods = []string{
+	http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+	http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace,
+}
+
+type requestLabels struct {
+	method string
+	status string
+}
+
+// Metrics counts served requests, it is populated by LogRequestHandler and
+// serves them in the Prometheus text exposition format
+type Metrics struct {
+	mu       sync.Mutex
+	requests map[requestLabels]uint64
+	buckets  []float64
+	// observations per bucket, not cumulative, the last one is +Inf
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		requests: map[requestLabels]uint64{},
+		buckets:  DefaultDurationBuckets,
+		counts:   make([]uint64, len(DefaultDurationBuckets)+1),
+	}
+}
+
+func (m *Metrics) observe(method string, code int, duration time.Duration) {
+	labels := requestLabels{method: "OTHER", status: statusClass(code)}
+	for _, known := range metricsMethods {
+		if method == known {
+			labels.method = method
+			break
+		}
+	}
+	seconds := duration.Seconds()
+	bucket := sort.SearchFloat64s(m.buckets, seconds)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[labels]++
+	m.counts[bucket]++
+	m.count++
+	m.sum += seconds
+}
+
+// statusClass returns "2xx" for 200 etc., an unwritten code (0) is an implicit 200
+func statusClass(code int) string {
+	if code == 0 {
+		code = http.StatusOK
+	}
+	return strconv.Itoa(code/100) + "xx"
+}
+
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var out strings.Builder
+
+	m.mu.Lock()
+	labels := make([]requestLabels, 0, len(m.requests))
+	for l := range m.requests {
+		labels = append(labels, l)
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		if labels[i].method != labels[j].method {
+			return labels[i].method < labels[j].method
+		}
+		return labels[i].status < labels[j].status
+	})
+
+	out.WriteString("# HELP http_requests_total Number of HTTP requests served.\n")
+	out.WriteString("# TYPE http_requests_total counter\n")
+	for _, l := range labels {
+		out.WriteString(`http_requests_total{method="` + l.method + `",status="` + l.status + `"} `)
+		out.WriteString(strconv.FormatUint(m.requests[l], 10) + "\n")
+	}
+
+	out.WriteString("# HELP http_request_duration_seconds Duration of HTTP requests.\n")
+	out.WriteString("# TYPE http_request_duration_seconds histogram\n")
+	var cumulative uint64
+	for i, count := range m.counts {
+		cumulative += count
+		le := "+Inf"
+		if i < len(m.buckets) {
+			le = strconv.FormatFloat(m.buckets[i], 'g', -1, 64)
+		}
+		out.WriteString(`http_request_duration_seconds_bucket{le="` + le + `"} ` + strconv.FormatUint(cumulative, 10) + "\n")
+	}
+	out.WriteString("http_request_duration_seconds_sum " + strconv.FormatFloat(m.sum, 'g', -1, 64) + "\n")
+	out.WriteString("http_request_duration_seconds_count " + strconv.FormatUint(m.count, 10) + "\n")
+	m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	_, _ = w.Write([]byte(out.String()))
+}
@@ -0,0 +1,75 @@
+package util
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+		case "/broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+
+	for _, disabled := range []bool{false, true} {
+		metrics := NewMetrics()
+		var buf bytes.Buffer
+		handler := logRequestHandler(inner, &LogRequestHandlerOptions{Disabled: disabled, Metrics: metrics, SkipPaths: []string{"/healthz"}}, &buf)
+
+		requests := []struct {
+			method string
+			target string
+		}{
+			{"GET", "/"},
+			{"GET", "/app.js"},
+			{"GET", "/missing"},
+			{"POST", "/broken"},
+			{"BREW", "/"},
+			{"GET", "/healthz"},
+		}
+		for _, req := range requests {
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(req.method, req.target, nil))
+		}
+
+		expected := map[requestLabels]uint64{
+			{"GET", "2xx"}:   2,
+			{"GET", "4xx"}:   1,
+			{"POST", "5xx"}:  1,
+			{"OTHER", "2xx"}: 1,
+		}
+		if len(metrics.requests) != len(expected) {
+			t.Errorf("Disabled %t: expected %v, got %v", disabled, expected, metrics.requests)
+		}
+		for labels, count := range expected {
+			if metrics.requests[labels] != count {
+				t.Errorf("Disabled %t: expected %d requests for %v, got %d", disabled, count, labels, metrics.requests[labels])
+			}
+		}
+		if disabled && buf.Len() != 0 {
+			t.Errorf("Expected no access lines while disabled, got: %s", buf.String())
+		}
+
+		recorder := httptest.NewRecorder()
+		metrics.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+		body := recorder.Body.String()
+		for _, line := range []string{
+			"# TYPE http_requests_total counter\n",
+			`http_requests_total{method="GET",status="2xx"} 2` + "\n",
+			`http_requests_total{method="POST",status="5xx"} 1` + "\n",
+			"# TYPE http_request_duration_seconds histogram\n",
+			`http_request_duration_seconds_bucket{le="+Inf"} 5` + "\n",
+			"http_request_duration_seconds_count 5\n",
+		} {
+			if !strings.Contains(body, line) {
+				t.Errorf("Disabled %t: expected %q in:\n%s", disabled, line, body)
+			}
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package util
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// HTTPSRedirectHandler permanently redirects every request to its https://
+// equivalent on httpsPort, keeping host, path and query
+func HTTPSRedirectHandler(httpsPort int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if hostname, _, err := net.SplitHostPort(host); err == nil {
+			host = hostname
+		} else {
+			host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+		}
+		if httpsPort != 0 && httpsPort != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(httpsPort))
+		} else if strings.Contains(host, ":") {
+			// an IPv6 literal, its brackets were dropped above
+			host = "[" + host + "]"
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
@@ -0,0 +1,33 @@
+package util
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHTTPSRedirectHandler(t *testing.T) {
+	tests := []struct {
+		httpsPort int
+		host      string
+		target    string
+		location  string
+	}{
+		{443, "example.com", "/dashboard?tab=2&q=a%20b", "https://example.com/dashboard?tab=2&q=a%20b"},
+		{443, "example.com:80", "/", "https://example.com/"},
+		{8443, "example.com:8080", "/app.js?v=1", "https://example.com:8443/app.js?v=1"},
+		{443, "[::1]:80", "/x", "https://[::1]/x"},
+		{8443, "[::1]", "/x", "https://[::1]:8443/x"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.target, nil)
+		req.Host = tt.host
+		recorder := httptest.NewRecorder()
+		HTTPSRedirectHandler(tt.httpsPort).ServeHTTP(recorder, req)
+
+		if recorder.Code != http.StatusMovedPermanently || recorder.Header().Get("Location") != tt.location {
+			t.Errorf("%s%s: expected 301 to %s, got %d %s", tt.host, tt.target, tt.location, recorder.Code, recorder.Header().Get("Location"))
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package util
+
+import "net/http"
+
+// SecurityHeaders are set on every response, empty values are not set
+type SecurityHeaders struct {
+	ContentSecurityPolicy   string
+	StrictTransportSecurity string
+	XFrameOptions           string
+	XContentTypeOptions     string
+}
+
+func (s SecurityHeaders) empty() bool {
+	return s == SecurityHeaders{}
+}
+
+// SecurityHeadersHandler sets the configured headers before h runs, so a value
+// h sets for its own response replaces the configured one
+func SecurityHeadersHandler(h http.Handler, headers SecurityHeaders) http.Handler {
+	if headers.empty() {
+		return h
+	}
+	values := []struct {
+		name  string
+		value string
+	}{
+		{"Content-Security-Policy", headers.ContentSecurityPolicy},
+		{"Strict-Transport-Security", headers.StrictTransportSecurity},
+		{"X-Frame-Options", headers.XFrameOptions},
+		{"X-Content-Type-Options", headers.XContentTypeOptions},
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := w.Header()
+		for _, v := range values {
+			if v.value != "" && header.Get(v.name) == "" {
+				header.Set(v.name, v.value)
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,52 @@
+package util
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSecurityHeadersHandler(t *testing.T) {
+	headers := SecurityHeaders{
+		ContentSecurityPolicy:   "default-src 'self'",
+		StrictTransportSecurity: "max-age=63072000; includeSubDomains",
+		XFrameOptions:           "DENY",
+	}
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/embed" {
+			w.Header().Set("X-Frame-Options", "SAMEORIGIN")
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := SecurityHeadersHandler(inner, headers)
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+	expected := map[string]string{
+		"Content-Security-Policy":   "default-src 'self'",
+		"Strict-Transport-Security": "max-age=63072000; includeSubDomains",
+		"X-Frame-Options":           "DENY",
+	}
+	for name, value := range expected {
+		if got := recorder.Header().Get(name); got != value {
+			t.Errorf("Expected %s %q, got %q", name, value, got)
+		}
+	}
+	if _, ok := recorder.Header()["X-Content-Type-Options"]; ok {
+		t.Errorf("Expected no X-Content-Type-Options when left empty")
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/embed", nil))
+	if got := recorder.Header().Get("X-Frame-Options"); got != "SAMEORIGIN" {
+		t.Errorf("Expected the inner handler's X-Frame-Options to be kept, got %q", got)
+	}
+
+	recorder = httptest.NewRecorder()
+	SecurityHeadersHandler(inner, SecurityHeaders{}).ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+	for name := range expected {
+		if _, ok := recorder.Header()[name]; ok {
+			t.Errorf("Expected no %s without configuration", name)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package util
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// TraceFormat selects the header trace and span IDs are extracted from
+type TraceFormat string
+
+const (
+	// TraceFormatW3C reads the W3C Trace Context "traceparent" header
+	TraceFormatW3C TraceFormat = "w3c"
+	// TraceFormatAWS reads the AWS X-Ray "X-Amzn-Trace-Id" header
+	TraceFormatAWS TraceFormat = "aws"
+	// TraceFormatGCP reads the Google Cloud "X-Cloud-Trace-Context" header
+	TraceFormatGCP TraceFormat = "gcp"
+)
+
+func ParseTraceFormat(s string) (TraceFormat, error) {
+	switch format := TraceFormat(strings.ToLower(s)); format {
+	case TraceFormatW3C, TraceFormatAWS, TraceFormatGCP:
+		return format, nil
+	default:
+		return "", fmt.Errorf("unknown trace format %q, expected one of w3c, aws or gcp", s)
+	}
+}
+
+func isHex(s string, length int) bool {
+	if len(s) != length {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// parseTraceparent parses "<version>-<trace-id>-<parent-id>-<flags>"
+func parseTraceparent(value string) (string, string, bool) {
+	parts := strings.Split(value, "-")
+	if len(parts) < 4 || !isHex(parts[0], 2) || parts[0] == "ff" || !isHex(parts[1], 32) || !isHex(parts[2], 16) {
+		return "", "", false
+	}
+	if strings.Trim(parts[1], "0") == "" || strings.Trim(parts[2], "0") == "" {
+		return "", "", false
+	}
+	return strings.ToLower(parts[1]), strings.ToLower(parts[2]), true
+}
+
+// parseAmznTraceId parses "Root=<trace-id>;Parent=<span-id>;Sampled=<flag>",
+// Parent is missing on requests entering at the load balancer
+func parseAmznTraceId(value string) (string, string, bool) {
+	var traceID, spanID string
+	for _, field := range strings.Split(value, ";") {
+		key, val, _ := strings.Cut(strings.TrimSpace(field), "=")
+		switch key {
+		case "Root":
+			traceID = val
+		case "Parent":
+			spanID = val
+		}
+	}
+	return traceID, spanID, traceID != ""
+}
+
+// parseCloudTraceContext parses "<trace-id>/<span-id>;o=<flag>"
+func parseCloudTraceContext(value string) (string, string, bool) {
+	value, _, _ = strings.Cut(value, ";")
+	traceID, spanID, _ := strings.Cut(value, "/")
+	if !isHex(traceID, 32) {
+		return "", "", false
+	}
+	return strings.ToLower(traceID), spanID, true
+}
+
+// traceIDs returns the IDs from the first of formats present and valid on the request
+func traceIDs(r *http.Request, formats []TraceFormat) (string, string) {
+	for _, format := range formats {
+		var traceID, spanID string
+		var ok bool
+		switch format {
+		case TraceFormatW3C:
+			traceID, spanID, ok = parseTraceparent(r.Header.Get("traceparent"))
+		case TraceFormatAWS:
+			traceID, spanID, ok = parseAmznTraceId(r.Header.Get("X-Amzn-Trace-Id"))
+		case TraceFormatGCP:
+			traceID, spanID, ok = parseCloudTraceContext(r.Header.Get("X-Cloud-Trace-Context"))
+		}
+		if ok {
+			return traceID, spanID
+		}
+	}
+	return "", ""
+}
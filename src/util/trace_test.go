@@ -0,0 +1,107 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTraceIDs(t *testing.T) {
+	tests := []struct {
+		name    string
+		formats []TraceFormat
+		headers map[string]string
+		traceID string
+		spanID  string
+	}{
+		{
+			name:    "w3c traceparent",
+			formats: []TraceFormat{TraceFormatW3C},
+			headers: map[string]string{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+			traceID: "4bf92f3577b34da6a3ce929d0e0e4736",
+			spanID:  "00f067aa0ba902b7",
+		},
+		{
+			name:    "w3c all zero trace id",
+			formats: []TraceFormat{TraceFormatW3C},
+			headers: map[string]string{"traceparent": "00-00000000000000000000000000000000-00f067aa0ba902b7-01"},
+		},
+		{
+			name:    "w3c malformed",
+			formats: []TraceFormat{TraceFormatW3C},
+			headers: map[string]string{"traceparent": "00-4bf92f35-00f067aa0ba902b7-01"},
+		},
+		{
+			name:    "aws root and parent",
+			formats: []TraceFormat{TraceFormatAWS},
+			headers: map[string]string{"X-Amzn-Trace-Id": "Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1"},
+			traceID: "1-5759e988-bd862e3fe1be46a994272793",
+			spanID:  "53995c3f42cd8ad8",
+		},
+		{
+			name:    "aws root only",
+			formats: []TraceFormat{TraceFormatAWS},
+			headers: map[string]string{"X-Amzn-Trace-Id": "Root=1-5759e988-bd862e3fe1be46a994272793"},
+			traceID: "1-5759e988-bd862e3fe1be46a994272793",
+		},
+		{
+			name:    "gcp",
+			formats: []TraceFormat{TraceFormatGCP},
+			headers: map[string]string{"X-Cloud-Trace-Context": "105445aa7843bc8bf206b12000100000/1;o=1"},
+			traceID: "105445aa7843bc8bf206b12000100000",
+			spanID:  "1",
+		},
+		{
+			name:    "first available format wins",
+			formats: []TraceFormat{TraceFormatW3C, TraceFormatGCP},
+			headers: map[string]string{"X-Cloud-Trace-Context": "105445aa7843bc8bf206b12000100000/1;o=1"},
+			traceID: "105445aa7843bc8bf206b12000100000",
+			spanID:  "1",
+		},
+		{
+			name:    "header of a format not selected",
+			formats: []TraceFormat{TraceFormatAWS},
+			headers: map[string]string{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			for key, value := range tt.headers {
+				req.Header.Set(key, value)
+			}
+			traceID, spanID := traceIDs(req, tt.formats)
+			if traceID != tt.traceID || spanID != tt.spanID {
+				t.Errorf("Expected %q %q, got %q %q", tt.traceID, tt.spanID, traceID, spanID)
+			}
+		})
+	}
+}
+
+func TestLogRequestHandlerTraceIDs(t *testing.T) {
+	var buf bytes.Buffer
+	handler := logRequestHandler(&countingHandler{}, &LogRequestHandlerOptions{TraceFormats: []TraceFormat{TraceFormatW3C}}, &buf)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	var logData map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &logData); err != nil {
+		t.Fatalf("Failed to parse log output as JSON: %v\nLog output: %s", err, buf.String())
+	}
+	if logData["traceId"] != "4bf92f3577b34da6a3ce929d0e0e4736" || logData["spanId"] != "00f067aa0ba902b7" {
+		t.Errorf("Expected trace and span IDs from traceparent, got: %s", buf.String())
+	}
+}
+
+func TestParseTraceFormat(t *testing.T) {
+	if format, err := ParseTraceFormat("W3C"); err != nil || format != TraceFormatW3C {
+		t.Errorf("Expected w3c, got %s (%v)", format, err)
+	}
+	if _, err := ParseTraceFormat("zipkin"); err == nil {
+		t.Errorf("Expected unknown trace format to be rejected")
+	}
+}